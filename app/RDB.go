@@ -2,239 +2,345 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash/crc64"
+	"io"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/rdb"
 )
 
+// 写入 RDB 辅助字段 redis-ver 的版本号，格式常量见 internal/rdb
+const rdbRedisVersion = "7.2.0"
+
 // RDB 相关配置
 var rdbConfig = struct {
 	sync.RWMutex
 	dir        string
 	dbfilename string
+	save       string // 自动保存规则，如 "3600 1 300 100"，空字符串表示关闭
+
+	saveParams       []saveParam
+	lastSave         time.Time // 上次成功保存的时间
+	lastBgsaveTry    time.Time // 上次尝试 BGSAVE 的时间
+	lastBgsaveStatus string
+	bgsaveInProgress bool
 }{
-	dir:        "./data",   
-	dbfilename: "dump.rdb", 
+	dir:              "./data",
+	dbfilename:       "dump.rdb",
+	save:             "3600 1 300 100 60 10000",
+	lastSave:         time.Now(),
+	lastBgsaveStatus: "ok",
 }
 
-// 读取 RDB 文件：只读出database部分就行
-func LoadRDB(dir, dbfilename string)  error {
-	// 打开 RDB 文件
-	filePath := dir + "/" + dbfilename
-	file, err := os.Open(filePath)
+// 自动保存规则：seconds 秒内至少有 changes 次修改时触发 BGSAVE
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// 解析 save 配置，格式为成对的 "<seconds> <changes>"
+func parseSaveParams(save string) ([]saveParam, error) {
+	fields := strings.Fields(save)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("save parameters must be pairs of <seconds> <changes>")
+	}
+	var params []saveParam
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters '%s %s'", fields[i], fields[i+1])
+		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	return params, nil
+}
+
+// 读取 RDB 文件，文件不存在时以空数据库启动
+func LoadRDB(dir, dbfilename string) error {
+	file, err := os.Open(dir + "/" + dbfilename)
+	if os.IsNotExist(err) {
+		fmt.Println("RDB file not found, starting with an empty dataset")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	
-	var header [9]byte
-	var dbIndex uint8
-	var hashSize uint32
-	_, err = file.Read(header[:])
+	start := time.Now()
+	stats, err := loadRDB(file)
 	if err != nil {
-		return  err
+		return fmt.Errorf("%s: %v", dbfilename, err)
 	}
+	fmt.Printf("Loading RDB produced by version %s\n", stats.redisVer)
+	fmt.Printf("DB loaded from disk: %d keys loaded, %d expired keys skipped, %d keys in other databases skipped, %d module keys skipped (%.3f seconds)\n",
+		stats.loaded, stats.expired, stats.otherDB, stats.skipped, time.Since(start).Seconds())
+	return nil
+}
+
+// 加载过程中的统计
+type rdbLoadStats struct {
+	redisVer string // 生成文件的 Redis 版本（辅助字段 redis-ver）
+	loaded   int    // 载入的 key 数
+	expired  int    // 已过期而跳过的 key 数
+	otherDB  int    // 不在 0 号数据库而跳过的 key 数（本服务只有一个数据库）
+	skipped  int    // 不支持的类型（模块）而跳过的 key 数
+	size     int64  // 读取的字节数（包括校验和）
+}
+
+// 从 r 中读取完整的 RDB 数据并载入 store，格式的解析在 internal/rdb 中
+func loadRDB(r io.Reader) (rdbLoadStats, error) {
+	return loadRDBWith(r, storeLoadValue)
+}
 
-	// 检查 header 是否匹配 REDIS0011
-	if string(header[:]) != "REDIS0011" {
-		return  errors.New("invalid RDB header")
+// 从 r 中读取完整的 RDB 数据，每个需要载入的 key 交给 load（无盘同步的 swapdb 先解析到内存）
+func loadRDBWith(r io.Reader, load func(key string, value interface{}, expireAt int64) error) (rdbLoadStats, error) {
+	var stats rdbLoadStats
+	reader, err := rdb.NewReader(r)
+	if err != nil {
+		return stats, err
 	}
 
-	// 跳过元数据部分，直到找到数据库部分的键值对部分
+	now := time.Now().UnixNano() / 1e6
 	for {
-		// 读取每个部分
-		partHeader := make([]byte, 1)
-		_, err := file.Read(partHeader)
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return err
+			return stats, err
 		}
 
-		if partHeader[0] == 0xFE {
-			// 读取数据库编号，但未使用它
-
-			err = binary.Read(file, binary.LittleEndian, &dbIndex)
-			if err != nil {
-				return err
+		switch {
+		case entry.Value == nil:
+			fmt.Printf("Skipping value of unsupported module type for key '%s'\n", entry.Key)
+			stats.skipped++
+		case entry.DB != 0:
+			stats.otherDB++
+		case entry.ExpireAt != 0 && entry.ExpireAt <= now:
+			stats.expired++ // 和 Redis 主节点一样，不载入已过期的 key
+		default:
+			if err := load(entry.Key, entry.Value, entry.ExpireAt); err != nil {
+				return stats, err
 			}
+			stats.loaded++
 		}
-		if partHeader[0] == 0xFB {
-			// 读取哈希表大小信息（根据大小编码格式处理）
-			hashSize, err = readSizeEncoded(file)
-			if err != nil {
-				return err
-			}
+	}
+	if len(reader.Functions) > 0 {
+		fmt.Printf("Skipping %d function libraries stored in RDB: functions are not supported\n", len(reader.Functions))
+	}
+	stats.redisVer = reader.Aux["redis-ver"]
+	stats.size = reader.Offset()
+	return stats, nil
+}
 
-			// 跳过过期哈希表
-			_, err := readSizeEncoded(file)
-			if err != nil {
-				return err
-			}
-		
-		// 读取每个键值对
-			for i := 0; i < int(hashSize); i++ {
-				// 读取每个键值对的部分
-				partHeader := make([]byte, 1)
-				_, err := file.Read(partHeader)
-				if err != nil {
-					return err
-				}
-
-				if partHeader[0] == 0xFD {
-					// 跳过过期时间 (4 字节)
-					var expiryTime uint32
-					err := binary.Read(file, binary.LittleEndian, &expiryTime)
-					if err != nil {
-						fmt.Println("Reached EOF unexpectedly while reading expiry time.")
-						return err
-					}
-
-					// 读取值类型标志（1 字节），不使用
-					var valueType byte
-					err = binary.Read(file, binary.LittleEndian, &valueType)
-					if err != nil {
-						fmt.Println("Reached EOF unexpectedly while reading value type.")
-						return err
-					}
-					if(valueType!= 0x00){
-						fmt.Println("value type must be String.",valueType)
-					}
-					
-					// 读取key
-					key, err := readString(file)
-					if err != nil {
-						return err
-					}
-					// 存储键值对和过期时间
-					value, err := readString(file)
-					if err != nil {
-						return err
-					}
-
-					// 将键值对和过期时间存储到内存中
-					// storeSet(key, value, int64(expiryTime)*1000) // 先不管过期时间，先存储键值对
-					storeSet(key, value, 0)
-						
-				}
-			}
-		}
-		if partHeader[0] == 0xFF {
-			// 文件结束部分
-			break
-		}
+// 保存 RDB 文件（SAVE 命令），下面4个小函数使用
+func SaveRDB(dir, dbfilename string) error {
+	rdbConfig.Lock()
+	if rdbConfig.bgsaveInProgress {
+		rdbConfig.Unlock()
+		return errors.New("Background save already in progress")
 	}
+	rdbConfig.Unlock()
 
+	snapshot := snapshotStore()
+	if err := saveRDBSnapshot(dir, dbfilename, snapshot); err != nil {
+		return err
+	}
+	rdbSaveDone(snapshot)
 	return nil
 }
 
-// 保存 RDB 文件，下面4个小函数使用
-func SaveRDB(dir, dbfilename string) error {
-	// 创建一个文件用于存储 RDB 数据
-	filePath := dir + "/" + dbfilename
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+// 后台保存 RDB 文件（BGSAVE 命令）：在锁内生成时间点一致的快照，在协程中写文件
+func BgsaveRDB(dir, dbfilename string) error {
+	rdbConfig.Lock()
+	if rdbConfig.bgsaveInProgress {
+		rdbConfig.Unlock()
+		return errors.New("Background save already in progress")
 	}
-	defer file.Close()
+	rdbConfig.bgsaveInProgress = true
+	rdbConfig.lastBgsaveTry = time.Now()
+	rdbConfig.Unlock()
 
-	// 使用 CRC64 校验和表
-	// 定义一个 CRC64 的 polynomial 和一个 CRC64 校验和表
-	// 用于计算整个文件的 CRC64 校验和
-	// 使用的是 CRC64-ECMA 表
-	table := crc64.MakeTable(crc64.ECMA)
+	snapshot := snapshotStore()
+	go func() {
+		err := saveRDBSnapshot(dir, dbfilename, snapshot)
 
-	// 创建一个缓冲区来存储数据
-	var buf bytes.Buffer
+		rdbConfig.Lock()
+		rdbConfig.bgsaveInProgress = false
+		if err != nil {
+			rdbConfig.lastBgsaveStatus = "err"
+		} else {
+			rdbConfig.lastBgsaveStatus = "ok"
+		}
+		rdbConfig.Unlock()
 
-	// 写入 MAGIC 字符串和版本号
-	magic := []byte{0x52, 0x45, 0x44, 0x49, 0x53} // MAGIC 字符串 "REDIS"
-	version := []byte{0x30, 0x30, 0x31, 0x31}     // 版本号 "0003"
-	buf.Write(magic)
-	buf.Write(version)
+		if err != nil {
+			fmt.Println("Background saving error:", err)
+			return
+		}
+		runOnExecutor(func() { rdbSaveDone(snapshot) })
+		fmt.Println("Background saving terminated with success")
+	}()
+	return nil
+}
 
-	// 写入元数据（Redis 版本信息）
-	writeMetadata(&buf)
+// 保存成功后更新修改计数和保存时间
+func rdbSaveDone(snapshot *storeSnapshot) {
+	storeResetDirty(snapshot.dirty)
+	rdbConfig.Lock()
+	rdbConfig.lastSave = time.Now()
+	rdbConfig.Unlock()
+}
 
-	// 写入数据库（此例使用单个数据库）
-	writeDatabase(&buf)
+// 把快照写入 RDB 文件：先写临时文件并 fsync，再 rename 覆盖，保存中途宕机也不会损坏原文件
+func saveRDBSnapshot(dir, dbfilename string, snapshot *storeSnapshot) error {
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, snapshot, false); err != nil {
+		return err
+	}
+	return writeRDBFile(dir, dbfilename, buf.Bytes())
+}
 
-	// 计算 CRC64 校验和
-	checksum := crc64.Checksum(buf.Bytes(), table)
+// BGSAVE 和全量同步可能同时写 RDB 文件，临时文件名相同，写文件时互斥
+var rdbFileLock sync.Mutex
 
-	// 将缓冲区的内容写入文件
-	file.Write(buf.Bytes())
+// 把编码好的 RDB 数据写入文件
+func writeRDBFile(dir, dbfilename string, data []byte) error {
+	rdbFileLock.Lock()
+	defer rdbFileLock.Unlock()
+	return writeFileAtomic(dir+"/"+dbfilename, data)
+}
 
-	// 写入结束标志和 CRC64 校验和并写入文件
-	writeEnd(file, checksum)
+// 把快照编码成完整的 RDB 数据，下面3个小函数使用
+// aofBase 表示写的是 AOF 的 base 文件（RDB 前导）
+func writeRDB(w io.Writer, snapshot *storeSnapshot, aofBase bool) error {
+	writer := rdb.NewWriter(w)
 
-	return nil
-}
+	// 写入 MAGIC 字符串 "REDIS" 和 4 位版本号
+	writer.WriteHeader()
 
-// 2-写入 RDB 文件的元数据部分,假设我们只写 Redis 版本信息
-func writeMetadata(buf *bytes.Buffer) {
-	// 这里假设我们只写 Redis 版本信息
-	metadata := []byte{0xFA} // 开始一个元数据字段
-	buf.Write(metadata)
+	// 写入元数据（辅助字段）
+	writeMetadata(writer, aofBase)
 
-	// 写入元数据名：redis-ver
-	redisVer := "redis-ver"
-	redisVerLen := byte(len(redisVer)) // 以字节表示的字符串长度
-	buf.Write([]byte{redisVerLen})     // 长度字段
-	buf.Write([]byte(redisVer))        // 写入元数据名
+	// 写入数据库（此例使用单个数据库）
+	if err := writeDatabase(writer, snapshot); err != nil {
+		return err
+	}
 
-	// 写入 Redis 版本：6.0.16
-	redisVersion := "6.0.16"
-	redisVersionLen := byte(len(redisVersion))
-	buf.Write([]byte{redisVersionLen}) // 长度字段
-	buf.Write([]byte(redisVersion))    // 写入版本号
+	// 写入结束标志和 CRC64 校验和
+	return writer.Close()
 }
 
-// 3.1-写入 RDB 文件的数据库部分的头部，假设我们只写一个数据库
-func writeDatabase(buf *bytes.Buffer) {
-	// 写入数据库部分：数据库选择标识（此处为数据库0）
-	buf.Write([]byte{0xFE, 0x00})
+// 由 serverCron 定期调用，满足任意一条 save 规则时触发 BGSAVE
+func saveCron() {
+	dirty := storeDirty()
 
-	// 写入数据库的哈希表大小和过期哈希表大小（假设为3和2）
-	buf.Write([]byte{0xFB})
-
-	// 1️⃣ 计算当前 store 中键值对的数量
-    totalnums := len(store.data) 
-	writeLengthEncodedInt(buf, totalnums) // 未过期哈希表大小
-	writeLengthEncodedInt(buf, 0) // 过期哈希表大小
+	rdbConfig.Lock()
+	if rdbConfig.bgsaveInProgress || len(rdbConfig.saveParams) == 0 {
+		rdbConfig.Unlock()
+		return
+	}
+	now := time.Now()
+	trigger := false
+	for _, param := range rdbConfig.saveParams {
+		// 上次 BGSAVE 失败时，至少等 5 秒再重试
+		if dirty >= param.changes && now.Sub(rdbConfig.lastSave) >= time.Duration(param.seconds)*time.Second &&
+			(rdbConfig.lastBgsaveStatus == "ok" || now.Sub(rdbConfig.lastBgsaveTry) >= 5*time.Second) {
+			fmt.Printf("%d changes in %d seconds. Saving...\n", param.changes, param.seconds)
+			trigger = true
+			break
+		}
+	}
+	dir, dbfilename := rdbConfig.dir, rdbConfig.dbfilename
+	rdbConfig.Unlock()
 
-	// 写入键值对
-	writeKeyValuePair(buf)
+	if trigger {
+		if err := BgsaveRDB(dir, dbfilename); err != nil {
+			fmt.Println("Error starting background save:", err)
+		}
+	}
 }
 
-// 3.2-写入 RDB 文件数据库部分的键值对部分
-func writeKeyValuePair(buf *bytes.Buffer) {
-	// 假设键 "foo" 和值 "bar"，没有过期时间
-	// 写入过期时间（FD 4字节无符号整数，秒）
-	// 遍历 store.data 里的所有键值对
-	for key, value := range store.data {
-		buf.Write([]byte{0xFD})
-		writeUint32(buf, uint32(time.Now().Unix())) // 写入过期时间戳（Unix时间戳）
+// INFO persistence 中 RDB 相关的字段
+func rdbInfo() string {
+	dirty := storeDirty()
+	rdbConfig.RLock()
+	defer rdbConfig.RUnlock()
+	inProgress := 0
+	if rdbConfig.bgsaveInProgress {
+		inProgress = 1
+	}
+	return fmt.Sprintf(
+		"rdb_changes_since_last_save:%d\r\nrdb_bgsave_in_progress:%d\r\nrdb_last_save_time:%d\r\nrdb_last_bgsave_status:%s",
+		dirty, inProgress, rdbConfig.lastSave.Unix(), rdbConfig.lastBgsaveStatus,
+	)
+}
 
-		// 写入值类型标志（先固定为字符串类型，标志为 0x00）
-		buf.Write([]byte{0x00})
+// INFO persistence
+func persistenceInfo() string {
+	return rdbInfo() + "\r\n" + aofInfo()
+}
 
-		// 写入键和值
-		writeString(buf, key)
-		writeString(buf, value)
+// 2-写入 RDB 文件的元数据部分：redis-ver、redis-bits、ctime、used-mem、aof-base
+func writeMetadata(writer *rdb.Writer, aofBase bool) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	writer.WriteAux("redis-ver", rdbRedisVersion)
+	writer.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	writer.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	writer.WriteAux("used-mem", strconv.FormatUint(mem.Alloc, 10))
+	if aofBase {
+		writer.WriteAux("aof-base", "1")
+	} else {
+		writer.WriteAux("aof-base", "0")
 	}
 }
 
-// 4-写入 RDB 文件的尾部并写入文件
-func writeEnd(file *os.File, checksum uint64) {
-	// 写入文件结束标志
-	file.Write([]byte{0xFF})
+// 3-写入 RDB 文件的数据库部分，假设我们只写一个数据库
+func writeDatabase(writer *rdb.Writer, snapshot *storeSnapshot) error {
+	// 数据库选择标识（此处为数据库0）、键总数和设置了过期时间的键数
+	writer.SelectDB(0, snapshot.keyCount(), len(snapshot.expires))
 
-	// 写入 CRC64 校验和（8 字节）
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, checksum)
-	file.Write(buf)
+	// 写入键值对，没有过期时间时 expires 中取到 0
+	for key, value := range snapshot.data {
+		if err := writer.WriteEntry(key, value, snapshot.expires[key]); err != nil {
+			return err
+		}
+	}
+	for key, list := range snapshot.lists {
+		if err := writer.WriteEntry(key, list, snapshot.expires[key]); err != nil {
+			return err
+		}
+	}
+	for key, set := range snapshot.sets {
+		if err := writer.WriteEntry(key, set, snapshot.expires[key]); err != nil {
+			return err
+		}
+	}
+	for key, zset := range snapshot.zsets {
+		if err := writer.WriteEntry(key, zset, snapshot.expires[key]); err != nil {
+			return err
+		}
+	}
+	for key, hash := range snapshot.hashes {
+		if err := writer.WriteEntry(key, hash, snapshot.expires[key]); err != nil {
+			return err
+		}
+	}
+	for key, entries := range snapshot.streams {
+		stream := &rdb.Stream{Entries: entries, Groups: snapshot.groups[key]}
+		if err := writer.WriteEntry(key, stream, snapshot.expires[key]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
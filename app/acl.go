@@ -0,0 +1,900 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACL 用户
+type aclUser struct {
+	name        string
+	enabled     bool
+	nopass      bool            // 任何密码都可以登录
+	passwords   []string        // 密码的 SHA-256（十六进制小写），按添加顺序
+	allCommands bool            // +@all：包括没有分类的命令
+	commands    map[string]bool // 允许执行的命令（大写）
+	subcommands map[string]bool // "CONFIG|GET" 形式的子命令规则，true 允许、false 禁止，优先于 commands
+	cmdRules    []string        // 生效的命令规则，ACL LIST / GETUSER 按原样展示
+	keys        []aclKeyPattern
+	channels    []string // 允许的发布订阅频道模式
+}
+
+// key 模式和它允许的访问
+type aclKeyPattern struct {
+	pattern string
+	read    bool
+	write   bool
+}
+
+// ACL LOG 中的一条拒绝记录
+type aclLogEntry struct {
+	id         int64
+	count      int
+	reason     string // command、key、channel、auth
+	context    string // toplevel、multi
+	object     string // 被拒绝的命令、key、频道
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+const (
+	aclLogMaxLen        = 128              // 和 acllog-max-len 默认值一致
+	aclLogGroupInterval = 60 * time.Second // 这段时间内相同的拒绝合并成一条
+)
+
+// 所有 ACL 用户和拒绝记录
+var acl = struct {
+	sync.RWMutex
+	users     map[string]*aclUser
+	log       []*aclLogEntry // 新的在前
+	nextLogID int64
+	file      string // -aclfile，空表示不使用 ACL 文件
+}{users: make(map[string]*aclUser)}
+
+// 所有命令类别
+var aclCategoryNames = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap", "hyperloglog",
+	"geo", "stream", "pubsub", "admin", "fast", "slow", "blocking", "dangerous", "connection", "transaction", "scripting",
+}
+
+// 每个命令所属的类别
+var aclCommandCategories = map[string][]string{
+	"PING":         {"fast", "connection"},
+	"ECHO":         {"fast", "connection"},
+	"AUTH":         {"fast", "connection"},
+	"HELLO":        {"fast", "connection"},
+	"CLIENT":       {"slow", "connection"},
+	"SET":          {"write", "string", "slow"},
+	"GET":          {"read", "string", "fast"},
+	"DEL":          {"keyspace", "write", "slow"},
+	"INCR":         {"write", "string", "fast"},
+	"MSET":         {"write", "string", "slow"},
+	"TYPE":         {"keyspace", "read", "fast"},
+	"KEYS":         {"keyspace", "read", "slow", "dangerous"},
+	"RENAME":       {"keyspace", "write", "slow"},
+	"PEXPIREAT":    {"keyspace", "write", "fast"},
+	"XADD":         {"write", "stream", "fast"},
+	"XRANGE":       {"read", "stream", "slow"},
+	"XREAD":        {"read", "stream", "slow", "blocking"},
+	"PUBLISH":      {"pubsub", "fast"},
+	"SUBSCRIBE":    {"pubsub", "slow"},
+	"UNSUBSCRIBE":  {"pubsub", "slow"},
+	"MULTI":        {"fast", "transaction"},
+	"EXEC":         {"slow", "transaction"},
+	"CONFIG":       {"admin", "slow", "dangerous"},
+	"INFO":         {"slow", "dangerous"},
+	"SAVE":         {"admin", "slow", "dangerous"},
+	"BGSAVE":       {"admin", "slow", "dangerous"},
+	"LASTSAVE":     {"admin", "fast", "dangerous"},
+	"BGREWRITEAOF": {"admin", "slow", "dangerous"},
+	"DEBUG":        {"admin", "slow", "dangerous"},
+	"REPLCONF":     {"admin", "slow", "dangerous"},
+	"PSYNC":        {"admin", "slow", "dangerous"},
+	"REPLICAOF":    {"admin", "slow", "dangerous"},
+	"SLAVEOF":      {"admin", "slow", "dangerous"},
+	"WAIT":         {"slow", "connection"},
+	"WAITAOF":      {"slow", "connection"},
+	"ACL":          {"admin", "slow", "dangerous"},
+}
+
+// 创建一个新用户，和 Redis 一样默认是 off resetchannels -@all
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:        name,
+		commands:    make(map[string]bool),
+		subcommands: make(map[string]bool),
+		cmdRules:    []string{"-@all"},
+	}
+}
+
+// 复制用户，ACL SETUSER 在副本上应用规则，全部成功后才生效
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	c.keys = append([]aclKeyPattern(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	c.commands = make(map[string]bool, len(u.commands))
+	for cmd := range u.commands {
+		c.commands[cmd] = true
+	}
+	c.subcommands = make(map[string]bool, len(u.subcommands))
+	for sub, allowed := range u.subcommands {
+		c.subcommands[sub] = allowed
+	}
+	return &c
+}
+
+// 应用一条 ACL 规则
+func (u *aclUser) applyRule(rule string) error {
+	if rule == "" {
+		return fmt.Errorf("Syntax error")
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+		return nil
+	case "allkeys":
+		u.keys = []aclKeyPattern{{pattern: "*", read: true, write: true}}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.channels = []string{"*"}
+		return nil
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return u.applyRule("+@all")
+	case "nocommands":
+		return u.applyRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.applyRule(r)
+		}
+		return nil
+	}
+
+	switch rule[0] {
+	case '>':
+		u.addPassword(hashPassword(rule[1:]))
+		return nil
+	case '#':
+		hash := rule[1:]
+		if !isPasswordHash(hash) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.addPassword(hash)
+		return nil
+	case '<', '!':
+		hash := rule[1:]
+		if rule[0] == '<' {
+			hash = hashPassword(hash)
+		} else if !isPasswordHash(hash) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		for i, p := range u.passwords {
+			if p == hash {
+				u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("The password you are trying to remove from the user does not exist")
+	case '~':
+		u.keys = append(u.keys, aclKeyPattern{pattern: rule[1:], read: true, write: true})
+		return nil
+	case '%':
+		// %R~pattern、%W~pattern、%RW~pattern
+		perms, pattern, found := strings.Cut(rule[1:], "~")
+		if !found || perms == "" {
+			return fmt.Errorf("Syntax error")
+		}
+		key := aclKeyPattern{pattern: pattern}
+		for _, p := range strings.ToUpper(perms) {
+			switch p {
+			case 'R':
+				key.read = true
+			case 'W':
+				key.write = true
+			default:
+				return fmt.Errorf("Syntax error")
+			}
+		}
+		u.keys = append(u.keys, key)
+		return nil
+	case '&':
+		u.channels = append(u.channels, rule[1:])
+		return nil
+	case '+', '-':
+		return u.applyCommandRule(strings.ToLower(rule))
+	}
+	return fmt.Errorf("Syntax error")
+}
+
+// 应用 +cmd、-cmd、+@category、-@category、+cmd|subcommand 规则
+func (u *aclUser) applyCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := rule[1:]
+
+	if strings.HasPrefix(name, "@") {
+		category := name[1:]
+		if category == "all" {
+			u.allCommands = allow
+			u.commands = make(map[string]bool)
+			u.subcommands = make(map[string]bool)
+			if allow {
+				for cmd := range aclCommandCategories {
+					u.commands[cmd] = true
+				}
+			}
+			u.cmdRules = []string{rule}
+			return nil
+		}
+		commands := commandsInCategory(category)
+		if commands == nil {
+			return fmt.Errorf("Unknown command category")
+		}
+		for _, cmd := range commands {
+			u.setCommand(cmd, allow)
+		}
+		u.cmdRules = append(u.cmdRules, rule)
+		return nil
+	}
+
+	cmd, sub, isSub := strings.Cut(strings.ToUpper(name), "|")
+	if _, exists := aclCommandCategories[cmd]; !exists {
+		return fmt.Errorf("Unknown command")
+	}
+	if isSub {
+		if sub == "" {
+			return fmt.Errorf("Syntax error")
+		}
+		u.subcommands[cmd+"|"+sub] = allow
+		if !allow {
+			u.allCommands = false
+		}
+	} else {
+		u.setCommand(cmd, allow)
+	}
+	u.cmdRules = append(u.cmdRules, rule)
+	return nil
+}
+
+// 允许或禁止一个命令，同时清除它的子命令规则
+func (u *aclUser) setCommand(cmd string, allow bool) {
+	if allow {
+		u.commands[cmd] = true
+	} else {
+		delete(u.commands, cmd)
+		u.allCommands = false
+	}
+	for sub := range u.subcommands {
+		if strings.HasPrefix(sub, cmd+"|") {
+			delete(u.subcommands, sub)
+		}
+	}
+}
+
+// 添加密码（SHA-256），已有的不重复添加
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+// 密码的 SHA-256，ACL 只保存哈希
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// 是否是合法的密码哈希：64 个小写十六进制字符
+func isPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// 类别包含的命令（大写、排序），未知类别返回 nil
+func commandsInCategory(category string) []string {
+	known := false
+	for _, name := range aclCategoryNames {
+		if name == category {
+			known = true
+		}
+	}
+	if !known {
+		return nil
+	}
+	commands := []string{}
+	for cmd, categories := range aclCommandCategories {
+		for _, c := range categories {
+			if c == category {
+				commands = append(commands, cmd)
+			}
+		}
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// 用户能否执行命令，调用时持有 acl 读锁
+func (u *aclUser) canRun(cmd string, args []string) bool {
+	if len(args) > 0 {
+		if allowed, exists := u.subcommands[cmd+"|"+strings.ToUpper(args[0])]; exists {
+			return allowed
+		}
+	}
+	return u.allCommands || u.commands[cmd]
+}
+
+// 用户能否以指定方式访问 key，调用时持有 acl 读锁
+func (u *aclUser) canAccessKey(key string, write bool) bool {
+	for _, k := range u.keys {
+		if (write && !k.write) || (!write && !k.read) {
+			continue
+		}
+		if stringMatch(k.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// 用户能否访问频道，调用时持有 acl 读锁
+func (u *aclUser) canAccessChannel(channel string) bool {
+	for _, pattern := range u.channels {
+		if stringMatch(pattern, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// 命令访问的频道
+func commandChannels(cmd string, args []string) []string {
+	switch cmd {
+	case "PUBLISH":
+		if len(args) > 0 {
+			return args[:1]
+		}
+	case "SUBSCRIBE":
+		return args
+	}
+	return nil
+}
+
+// ACL LIST 中的一行
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name}
+	parts = append(parts, u.flags()...)
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if keys := u.describeKeys(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.describeChannels(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.cmdRules...)
+	return strings.Join(parts, " ")
+}
+
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) describeKeys() string {
+	var parts []string
+	for _, k := range u.keys {
+		switch {
+		case k.read && k.write:
+			parts = append(parts, "~"+k.pattern)
+		case k.read:
+			parts = append(parts, "%R~"+k.pattern)
+		default:
+			parts = append(parts, "%W~"+k.pattern)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (u *aclUser) describeChannels() string {
+	var parts []string
+	for _, c := range u.channels {
+		parts = append(parts, "&"+c)
+	}
+	return strings.Join(parts, " ")
+}
+
+// 启动时创建 default 用户，设置了 -aclfile 时从文件载入用户
+func initACL() error {
+	user := newACLUser("default")
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "+@all"} {
+		user.applyRule(rule)
+	}
+	if authConfig.requirepass != "" {
+		user.applyRule(">" + authConfig.requirepass)
+	}
+	acl.users["default"] = user
+
+	if acl.file == "" {
+		return nil
+	}
+	users, err := readACLFile(acl.file)
+	if os.IsNotExist(err) {
+		fmt.Println("ACL file not found, starting with the default user:", acl.file)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	replaceACLUsers(users)
+	return nil
+}
+
+// 读取 ACL 文件，每行一个 "user <name> <rules...>"，有任何错误都不生效
+func readACLFile(path string) (map[string]*aclUser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(file)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d should start with user keyword", path, lineno)
+		}
+		if _, exists := users[fields[1]]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineno, fields[1])
+		}
+		user := newACLUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v. Error in user declaration '%s'", path, lineno, err, fields[1])
+			}
+		}
+		users[user.name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// 文件中没有 default 用户时使用不需要密码、拥有所有权限的 default
+	if _, exists := users["default"]; !exists {
+		user := newACLUser("default")
+		for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "+@all"} {
+			user.applyRule(rule)
+		}
+		users["default"] = user
+	}
+	return users, nil
+}
+
+// 用新的用户集合替换现有用户：同名用户原地更新，已登录的连接立即使用新规则；被删除用户的连接断开
+func replaceACLUsers(users map[string]*aclUser) {
+	acl.Lock()
+	var removed []*aclUser
+	for name, old := range acl.users {
+		if user, exists := users[name]; exists {
+			*old = *user
+			users[name] = old
+		} else {
+			removed = append(removed, old)
+		}
+	}
+	acl.users = users
+	acl.Unlock()
+
+	for _, user := range removed {
+		disconnectUserClients(user)
+	}
+}
+
+// 把所有用户写入 ACL 文件，先写临时文件再 rename
+func saveACLFile(path string) error {
+	acl.RLock()
+	names := make([]string, 0, len(acl.users))
+	for name := range acl.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(acl.users[name].describe())
+		buf.WriteString("\n")
+	}
+	acl.RUnlock()
+
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(buf.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// 断开以这个用户登录的所有连接
+func disconnectUserClients(user *aclUser) {
+	clients.RLock()
+	defer clients.RUnlock()
+	for _, c := range clients.byID {
+		if c.aclUser() == user {
+			c.Conn.Close()
+		}
+	}
+}
+
+// 按用户名和密码登录，成功时返回用户
+func authenticateUser(username, password string) (*aclUser, bool) {
+	acl.RLock()
+	defer acl.RUnlock()
+	user, exists := acl.users[username]
+	if !exists || !user.enabled {
+		return nil, false
+	}
+	if user.nopass {
+		return user, true
+	}
+	hash := hashPassword(password)
+	for _, p := range user.passwords {
+		if p == hash {
+			return user, true
+		}
+	}
+	return nil, false
+}
+
+// 新连接使用 default 用户，default 不需要密码时直接通过认证
+func defaultUser() (*aclUser, bool) {
+	acl.RLock()
+	defer acl.RUnlock()
+	user := acl.users["default"]
+	return user, user.enabled && user.nopass
+}
+
+// 连接当前登录的用户
+func (c *Client) aclUser() *aclUser {
+	c.Lock()
+	defer c.Unlock()
+	return c.user
+}
+
+// 检查连接的用户能否执行命令、访问命令的 key 和频道，不能时返回 NOPERM 错误并记入 ACL LOG
+func checkCommandPermission(c *Client, cmd string, args []string) string {
+	user := c.aclUser()
+	context := "toplevel"
+	if c.inTransaction {
+		context = "multi"
+	}
+
+	acl.RLock()
+	username := user.name
+	var reason, object, reply string
+	if !user.canRun(cmd, args) {
+		reason, object = "command", strings.ToLower(cmd)
+		if len(args) > 0 {
+			if _, exists := user.subcommands[cmd+"|"+strings.ToUpper(args[0])]; exists {
+				object += "|" + strings.ToLower(args[0])
+			}
+		}
+		reply = fmt.Sprintf("-NOPERM User %s has no permissions to run the '%s' command\r\n", username, object)
+	} else {
+		write := isWriteCommand(cmd)
+		for _, key := range commandKeys(cmd, args) {
+			if !user.canAccessKey(key, write) {
+				reason, object = "key", key
+				reply = "-NOPERM No permissions to access a key\r\n"
+				break
+			}
+		}
+		if reply == "" {
+			for _, channel := range commandChannels(cmd, args) {
+				if !user.canAccessChannel(channel) {
+					reason, object = "channel", channel
+					reply = "-NOPERM No permissions to access a channel\r\n"
+					break
+				}
+			}
+		}
+	}
+	acl.RUnlock()
+
+	if reply != "" {
+		addACLLogEntry(reason, context, object, username, c)
+	}
+	return reply
+}
+
+// 客户端信息，ACL LOG 使用
+func (c *Client) info() string {
+	username := ""
+	if user := c.aclUser(); user != nil {
+		username = user.name
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s user=%s", c.ID, c.RemoteAddr(), c.LocalAddr(), c.Name, username)
+}
+
+// 记录一次拒绝，60 秒内相同的拒绝合并成一条
+func addACLLogEntry(reason, context, object, username string, c *Client) {
+	now := time.Now()
+	clientInfo := c.info()
+
+	acl.Lock()
+	defer acl.Unlock()
+	for _, entry := range acl.log {
+		if entry.reason == reason && entry.context == context && entry.object == object && entry.username == username &&
+			now.Sub(entry.updated) < aclLogGroupInterval {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = clientInfo
+			return
+		}
+	}
+	entry := &aclLogEntry{
+		id:         acl.nextLogID,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: clientInfo,
+		created:    now,
+		updated:    now,
+	}
+	acl.nextLogID++
+	acl.log = append([]*aclLogEntry{entry}, acl.log...)
+	if len(acl.log) > aclLogMaxLen {
+		acl.log = acl.log[:aclLogMaxLen]
+	}
+}
+
+// 处理 ACL 命令
+func handleACL(client *Client, args []string) string {
+	if len(args) < 1 {
+		return "-ERR wrong number of arguments for 'acl' command\r\n"
+	}
+	sub := strings.ToUpper(args[0])
+	args = args[1:]
+	switch sub {
+	case "WHOAMI":
+		return encodeBulkString(client.aclUser().name)
+	case "USERS":
+		acl.RLock()
+		names := make([]string, 0, len(acl.users))
+		for name := range acl.users {
+			names = append(names, name)
+		}
+		acl.RUnlock()
+		sort.Strings(names)
+		return encodeArray(names)
+	case "LIST":
+		acl.RLock()
+		names := make([]string, 0, len(acl.users))
+		for name := range acl.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := make([]string, 0, len(names))
+		for _, name := range names {
+			lines = append(lines, acl.users[name].describe())
+		}
+		acl.RUnlock()
+		return encodeArray(lines)
+	case "SETUSER":
+		if len(args) < 1 {
+			return "-ERR wrong number of arguments for 'acl|setuser' command\r\n"
+		}
+		return aclSetUser(args[0], args[1:])
+	case "GETUSER":
+		if len(args) != 1 {
+			return "-ERR wrong number of arguments for 'acl|getuser' command\r\n"
+		}
+		return aclGetUser(client, args[0])
+	case "DELUSER":
+		if len(args) < 1 {
+			return "-ERR wrong number of arguments for 'acl|deluser' command\r\n"
+		}
+		return aclDelUser(args)
+	case "CAT":
+		if len(args) == 0 {
+			return encodeArray(aclCategoryNames)
+		}
+		commands := commandsInCategory(strings.ToLower(args[0]))
+		if commands == nil {
+			return "-ERR Unknown category '" + args[0] + "'\r\n"
+		}
+		for i, cmd := range commands {
+			commands[i] = strings.ToLower(cmd)
+		}
+		return encodeArray(commands)
+	case "LOG":
+		return aclLog(client, args)
+	case "SAVE":
+		if acl.file == "" {
+			return "-ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.\r\n"
+		}
+		if err := saveACLFile(acl.file); err != nil {
+			return "-ERR There was an error trying to save the ACLs. Please check the server logs for more information\r\n"
+		}
+		return "+OK\r\n"
+	case "LOAD":
+		if acl.file == "" {
+			return "-ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.\r\n"
+		}
+		users, err := readACLFile(acl.file)
+		if err != nil {
+			return "-ERR " + err.Error() + "\r\n"
+		}
+		replaceACLUsers(users)
+		return "+OK\r\n"
+	}
+	return "-ERR unknown subcommand '" + sub + "'. Try ACL HELP.\r\n"
+}
+
+// ACL SETUSER <username> [rule ...]：规则全部合法才生效，已登录的连接立即使用新规则
+func aclSetUser(name string, rules []string) string {
+	acl.Lock()
+	defer acl.Unlock()
+	user, exists := acl.users[name]
+	if !exists {
+		user = newACLUser(name)
+	}
+	updated := user.clone()
+	for _, rule := range rules {
+		if err := updated.applyRule(rule); err != nil {
+			return fmt.Sprintf("-ERR Error in ACL SETUSER modifier '%s': %v\r\n", rule, err)
+		}
+	}
+	*user = *updated
+	acl.users[name] = user
+	return "+OK\r\n"
+}
+
+// ACL GETUSER <username>
+func aclGetUser(client *Client, name string) string {
+	acl.RLock()
+	user, exists := acl.users[name]
+	if !exists {
+		acl.RUnlock()
+		return "*-1\r\n"
+	}
+	fields := []string{
+		encodeBulkString("flags"), encodeArray(user.flags()),
+		encodeBulkString("passwords"), encodeArray(user.passwords),
+		encodeBulkString("commands"), encodeBulkString(strings.Join(user.cmdRules, " ")),
+		encodeBulkString("keys"), encodeBulkString(user.describeKeys()),
+		encodeBulkString("channels"), encodeBulkString(user.describeChannels()),
+		encodeBulkString("selectors"), "*0\r\n",
+	}
+	acl.RUnlock()
+	return encodeMap(client, fields)
+}
+
+// ACL DELUSER <username> [username ...]，返回删除的用户数
+func aclDelUser(names []string) string {
+	for _, name := range names {
+		if name == "default" {
+			return "-ERR The 'default' user cannot be removed\r\n"
+		}
+	}
+	acl.Lock()
+	var removed []*aclUser
+	for _, name := range names {
+		if user, exists := acl.users[name]; exists {
+			removed = append(removed, user)
+			delete(acl.users, name)
+		}
+	}
+	acl.Unlock()
+
+	for _, user := range removed {
+		disconnectUserClients(user)
+	}
+	return fmt.Sprintf(":%d\r\n", len(removed))
+}
+
+// ACL LOG [count | RESET]
+func aclLog(client *Client, args []string) string {
+	count := 10
+	if len(args) > 0 {
+		if strings.ToUpper(args[0]) == "RESET" {
+			acl.Lock()
+			acl.log = nil
+			acl.Unlock()
+			return "+OK\r\n"
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return "-ERR value is out of range, must be positive\r\n"
+		}
+		count = n
+	}
+
+	acl.RLock()
+	defer acl.RUnlock()
+	if count > len(acl.log) {
+		count = len(acl.log)
+	}
+	now := time.Now()
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("*%d\r\n", count))
+	for _, entry := range acl.log[:count] {
+		age := now.Sub(entry.created).Seconds()
+		buf.WriteString(encodeMap(client, []string{
+			encodeBulkString("count"), fmt.Sprintf(":%d\r\n", entry.count),
+			encodeBulkString("reason"), encodeBulkString(entry.reason),
+			encodeBulkString("context"), encodeBulkString(entry.context),
+			encodeBulkString("object"), encodeBulkString(entry.object),
+			encodeBulkString("username"), encodeBulkString(entry.username),
+			encodeBulkString("age-seconds"), encodeBulkString(strconv.FormatFloat(age, 'f', 3, 64)),
+			encodeBulkString("client-info"), encodeBulkString(entry.clientInfo),
+			encodeBulkString("entry-id"), fmt.Sprintf(":%d\r\n", entry.id),
+			encodeBulkString("timestamp-created"), fmt.Sprintf(":%d\r\n", entry.created.UnixMilli()),
+			encodeBulkString("timestamp-last-updated"), fmt.Sprintf(":%d\r\n", entry.updated.UnixMilli()),
+		}))
+	}
+	return buf.String()
+}
+
+// 编码键值对：RESP3 使用 map，RESP2 使用平铺的数组
+func encodeMap(client *Client, fields []string) string {
+	if client.respVersion() == 3 {
+		return fmt.Sprintf("%%%d\r\n%s", len(fields)/2, strings.Join(fields, ""))
+	}
+	return fmt.Sprintf("*%d\r\n%s", len(fields), strings.Join(fields, ""))
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/resp"
+)
+
+// AOF 相关配置和运行状态
+// 采用 Redis 7 的多文件布局：appenddirname 目录下一个 base 文件、若干 incr 文件和一个 manifest 清单
+var aofConfig = struct {
+	sync.Mutex
+	appendonly         string // yes|no，是否开启 AOF
+	filename           string // AOF 文件名前缀
+	dirname            string // 存放 AOF 文件的目录，位于 dir 下
+	fsync              string // always|everysec|no
+	loadTruncated      string // yes|no，文件尾部不完整时是否截断后继续加载
+	rewritePercentage  int    // 相对上次重写增长超过这个百分比时自动重写，0 表示关闭
+	rewriteMinSizeFlag string // 自动重写的最小文件大小，如 64mb
+	useRDBPreamble     string // yes|no，重写时 base 文件是否使用 RDB 格式
+
+	manifest           *aofManifest
+	file               *os.File  // 当前追加的 incr 文件
+	dirty              bool      // 有数据写入但还没有 fsync
+	lastFsync          time.Time // 上次 fsync 的时间
+	fsyncedOffset      int64     // 已经 fsync 的数据对应的复制偏移量，用于 WAITAOF
+	currentSize        int64     // base 加所有 incr 文件的总大小
+	baseSize           int64     // 上次重写（或启动加载）后的总大小，用于计算增长百分比
+	rewriteMinSize     int64
+	rewriteInProgress  bool
+	lastRewriteStatus  string
+	lastRewriteSeconds int64
+}{
+	appendonly:         "no",
+	filename:           "appendonly.aof",
+	dirname:            "appendonlydir",
+	fsync:              "everysec",
+	loadTruncated:      "yes",
+	rewritePercentage:  100,
+	rewriteMinSizeFlag: "64mb",
+	useRDBPreamble:     "yes",
+	lastRewriteStatus:  "ok",
+	lastRewriteSeconds: -1,
+}
+
+// 执行写命令并写入 AOF 时持有读锁，AOF 重写切换 incr 文件并生成快照时持有写锁
+// 保证每条写命令要么同时在快照和旧 incr 文件里，要么只在新 incr 文件里
+var writeGate sync.RWMutex
+
+// manifest 清单：记录当前有效的 base 文件和按顺序追加的 incr 文件
+type aofManifest struct {
+	baseName  string
+	baseSeq   int
+	incrNames []string
+	incrSeq   int // 最后一个 incr 文件的序号
+}
+
+// 是否开启 AOF
+func aofEnabled() bool {
+	aofConfig.Lock()
+	defer aofConfig.Unlock()
+	return aofConfig.appendonly == "yes"
+}
+
+// AOF 目录路径
+func aofDirPath() string {
+	rdbConfig.RLock()
+	defer rdbConfig.RUnlock()
+	return filepath.Join(rdbConfig.dir, aofConfig.dirname)
+}
+
+// manifest 文件路径
+func aofManifestPath() string {
+	return filepath.Join(aofDirPath(), aofConfig.filename+".manifest")
+}
+
+// 读取 manifest，不存在时返回 nil
+func loadManifest() (*aofManifest, error) {
+	content, err := os.ReadFile(aofManifestPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	manifest := &aofManifest{}
+	type incrFile struct {
+		name string
+		seq  int
+	}
+	var incrs []incrFile
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 格式：file <name> seq <n> type <b|i|h>
+		fields := strings.Fields(line)
+		info := make(map[string]string)
+		for i := 0; i+1 < len(fields); i += 2 {
+			info[fields[i]] = fields[i+1]
+		}
+		seq, err := strconv.Atoi(info["seq"])
+		if info["file"] == "" || err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest line: %s", line)
+		}
+		switch info["type"] {
+		case "b":
+			manifest.baseName, manifest.baseSeq = info["file"], seq
+		case "i":
+			incrs = append(incrs, incrFile{info["file"], seq})
+		case "h":
+			// 历史文件，等待删除，不需要加载
+		default:
+			return nil, fmt.Errorf("invalid AOF manifest file type: %s", line)
+		}
+	}
+	sort.Slice(incrs, func(i, j int) bool { return incrs[i].seq < incrs[j].seq })
+	for _, incr := range incrs {
+		manifest.incrNames = append(manifest.incrNames, incr.name)
+		manifest.incrSeq = incr.seq
+	}
+	return manifest, nil
+}
+
+// 写入 manifest：先写临时文件再原子 rename
+func persistManifest(manifest *aofManifest) error {
+	var buf strings.Builder
+	if manifest.baseName != "" {
+		buf.WriteString(fmt.Sprintf("file %s seq %d type b\n", manifest.baseName, manifest.baseSeq))
+	}
+	for i, name := range manifest.incrNames {
+		seq := manifest.incrSeq - len(manifest.incrNames) + 1 + i
+		buf.WriteString(fmt.Sprintf("file %s seq %d type i\n", name, seq))
+	}
+	return writeFileAtomic(aofManifestPath(), []byte(buf.String()))
+}
+
+// 先写同目录下的临时文件并 fsync，再 rename 覆盖目标文件
+func writeFileAtomic(path string, content []byte) error {
+	tmpPath := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// base/incr 文件名，base 文件使用 RDB 格式时以 .rdb 结尾
+func baseFileName(seq int) string {
+	if aofConfig.useRDBPreamble == "yes" {
+		return fmt.Sprintf("%s.%d.base.rdb", aofConfig.filename, seq)
+	}
+	return fmt.Sprintf("%s.%d.base.aof", aofConfig.filename, seq)
+}
+
+func incrFileName(seq int) string {
+	return fmt.Sprintf("%s.%d.incr.aof", aofConfig.filename, seq)
+}
+
+// 打开 AOF 用于追加，启动时在加载完成之后调用
+// 没有 manifest 时创建空的 base 文件；总是追加到最后一个 incr 文件
+func openAppendOnlyFile() error {
+	if err := os.MkdirAll(aofDirPath(), 0755); err != nil {
+		return err
+	}
+	minSize, err := parseMemorySize(aofConfig.rewriteMinSizeFlag)
+	if err != nil {
+		return fmt.Errorf("invalid auto-aof-rewrite-min-size: %v", err)
+	}
+
+	aofConfig.Lock()
+	defer aofConfig.Unlock()
+	aofConfig.rewriteMinSize = minSize
+
+	manifest := aofConfig.manifest
+	if manifest == nil {
+		manifest = &aofManifest{baseName: baseFileName(1), baseSeq: 1}
+		if err := writeFileAtomic(filepath.Join(aofDirPath(), manifest.baseName), nil); err != nil {
+			return err
+		}
+	}
+	if len(manifest.incrNames) == 0 {
+		manifest.incrSeq++
+		manifest.incrNames = append(manifest.incrNames, incrFileName(manifest.incrSeq))
+		if err := persistManifest(manifest); err != nil {
+			return err
+		}
+	}
+
+	last := manifest.incrNames[len(manifest.incrNames)-1]
+	file, err := os.OpenFile(filepath.Join(aofDirPath(), last), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	aofConfig.manifest = manifest
+	aofConfig.file = file
+	aofConfig.lastFsync = time.Now()
+	aofConfig.currentSize = manifestFilesSize(manifest)
+	aofConfig.baseSize = aofConfig.currentSize
+	return nil
+}
+
+// manifest 中所有文件的总大小
+func manifestFilesSize(manifest *aofManifest) int64 {
+	var total int64
+	for _, name := range append([]string{manifest.baseName}, manifest.incrNames...) {
+		if info, err := os.Stat(filepath.Join(aofDirPath(), name)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// 追加一组写命令，多条时用 MULTI/EXEC 包裹，保证加载时作为整体执行
+func feedAppendOnlyFileBatch(commands [][]string) {
+	if len(commands) == 0 {
+		return
+	}
+	aofConfig.Lock()
+	defer aofConfig.Unlock()
+	if aofConfig.file == nil {
+		return
+	}
+	n, err := aofConfig.file.WriteString(encodeCommandBatch(commands))
+	aofConfig.currentSize += int64(n)
+	if err != nil {
+		fmt.Println("Error writing to AOF:", err)
+		return
+	}
+	aofConfig.dirty = true
+	// always：回复客户端之前落盘
+	if aofConfig.fsync == "always" {
+		if err := aofConfig.file.Sync(); err != nil {
+			fmt.Println("Error syncing AOF:", err)
+		}
+		aofConfig.dirty = false
+		aofConfig.lastFsync = time.Now()
+	}
+}
+
+// 把一组命令编码成 RESP，多条时用 MULTI/EXEC 包裹（AOF 和复制流使用同样的格式）
+func encodeCommandBatch(commands [][]string) string {
+	var buf strings.Builder
+	if len(commands) > 1 {
+		buf.WriteString(encodeArray([]string{"MULTI"}))
+	}
+	for _, argv := range commands {
+		buf.WriteString(encodeArray(argv))
+	}
+	if len(commands) > 1 {
+		buf.WriteString(encodeArray([]string{"EXEC"}))
+	}
+	return buf.String()
+}
+
+// 已经 fsync 到 AOF 的复制偏移量，没有开启 AOF 时返回 -1（WAITAOF 和 slave 的 ACK 使用）
+func aofFsyncedOffset() int64 {
+	offset := currentReplOffset()
+	aofConfig.Lock()
+	defer aofConfig.Unlock()
+	if aofConfig.file == nil {
+		return -1
+	}
+	// always 模式写入时已经 fsync；没有未 fsync 的数据时，读取偏移量之前写入的数据都已经落盘
+	if aofConfig.fsync == "always" || !aofConfig.dirty {
+		return offset
+	}
+	return aofConfig.fsyncedOffset
+}
+
+// 由 serverCron 定期调用：everysec 模式下每秒 fsync 一次，并检查是否需要自动重写
+func aofCron() {
+	// 写命令先写入 AOF 再计入复制偏移量，fsync 之前读取的偏移量之前的数据都会落盘
+	offset := currentReplOffset()
+	synced := false
+	aofConfig.Lock()
+	if aofConfig.file != nil && aofConfig.fsync == "everysec" && aofConfig.dirty &&
+		time.Since(aofConfig.lastFsync) >= time.Second {
+		if err := aofConfig.file.Sync(); err != nil {
+			fmt.Println("Error syncing AOF:", err)
+		} else {
+			aofConfig.dirty = false
+			aofConfig.lastFsync = time.Now()
+			aofConfig.fsyncedOffset = offset
+			synced = true
+		}
+	}
+
+	needRewrite := false
+	if aofConfig.file != nil && !aofConfig.rewriteInProgress && aofConfig.rewritePercentage > 0 &&
+		aofConfig.currentSize > aofConfig.rewriteMinSize {
+		base := aofConfig.baseSize
+		if base == 0 {
+			base = 1
+		}
+		growth := (aofConfig.currentSize*100)/base - 100
+		needRewrite = growth >= int64(aofConfig.rewritePercentage)
+	}
+	aofConfig.Unlock()
+	if synced {
+		notifyReplAck()
+	}
+
+	if needRewrite {
+		fmt.Println("Starting automatic rewriting of AOF")
+		if err := rewriteAppendOnlyFileBackground(); err != nil {
+			fmt.Println("Error starting automatic AOF rewrite:", err)
+		}
+	}
+}
+
+// 处理 BGREWRITEAOF 命令
+func handleBGREWRITEAOF(args []string) string {
+	if len(args) > 0 {
+		return "-ERR wrong number of arguments for 'bgrewriteaof' command\r\n"
+	}
+	if err := rewriteAppendOnlyFileBackground(); err != nil {
+		return "-ERR " + err.Error() + "\r\n"
+	}
+	return "+Background append only file rewriting started\r\n"
+}
+
+// 后台重写 AOF
+// 开始时切换到新的 incr 文件并在同一时刻生成内存快照：重写期间的写命令都进入新 incr 文件，
+// 相当于重写缓冲区；快照写成新的 base 文件后，新 base 加新 incr 就是完整的数据
+func rewriteAppendOnlyFileBackground() error {
+	writeGate.Lock()
+	aofConfig.Lock()
+	if aofConfig.file == nil {
+		aofConfig.Unlock()
+		writeGate.Unlock()
+		return errors.New("AOF is not enabled")
+	}
+	if aofConfig.rewriteInProgress {
+		aofConfig.Unlock()
+		writeGate.Unlock()
+		return errors.New("Background append only file rewriting already in progress")
+	}
+
+	// 不使用 RDB 格式时 base 文件只能包含能重放的命令，有其他类型时拒绝重写，避免丢失数据
+	snapshot := snapshotStore()
+	if aofConfig.useRDBPreamble != "yes" {
+		if kind := unreplayableType(snapshot); kind != "" {
+			// 从当前大小重新计算增长，自动重写不会每次 cron 都重试
+			aofConfig.lastRewriteStatus = "err"
+			aofConfig.baseSize = aofConfig.currentSize
+			aofConfig.Unlock()
+			writeGate.Unlock()
+			return fmt.Errorf("Can't rewrite append only file without RDB preamble: dataset contains %s, set aof-use-rdb-preamble yes", kind)
+		}
+	}
+
+	// 1. 打开新的 incr 文件，并先把它加入 manifest，即使重写失败也不会丢失之后的写入
+	manifest := aofConfig.manifest
+	newIncrSeq := manifest.incrSeq + 1
+	newIncrName := incrFileName(newIncrSeq)
+	newFile, err := os.OpenFile(filepath.Join(aofDirPath(), newIncrName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		aofConfig.Unlock()
+		writeGate.Unlock()
+		return err
+	}
+	oldIncrNames := manifest.incrNames
+	manifest.incrNames = append(append([]string{}, oldIncrNames...), newIncrName)
+	manifest.incrSeq = newIncrSeq
+	if err := persistManifest(manifest); err != nil {
+		newFile.Close()
+		os.Remove(filepath.Join(aofDirPath(), newIncrName))
+		manifest.incrNames = oldIncrNames
+		manifest.incrSeq--
+		aofConfig.Unlock()
+		writeGate.Unlock()
+		return err
+	}
+	aofConfig.file.Sync()
+	aofConfig.file.Close()
+	aofConfig.file = newFile
+	aofConfig.dirty = false
+	aofConfig.rewriteInProgress = true
+	oldBaseName := manifest.baseName
+	newBaseSeq := manifest.baseSeq + 1
+
+	// 2. 快照和新 incr 文件在同一把写锁内生成，两者合起来就是完整的数据
+	aofConfig.Unlock()
+	writeGate.Unlock()
+
+	go func() {
+		start := time.Now()
+		err := finishAppendOnlyRewrite(snapshot, newBaseSeq, oldBaseName, oldIncrNames)
+
+		aofConfig.Lock()
+		aofConfig.rewriteInProgress = false
+		aofConfig.lastRewriteSeconds = int64(time.Since(start).Seconds())
+		if err != nil {
+			aofConfig.lastRewriteStatus = "err"
+			fmt.Println("Background AOF rewrite failed:", err)
+		} else {
+			aofConfig.lastRewriteStatus = "ok"
+			aofConfig.baseSize = aofConfig.currentSize
+			fmt.Println("Background AOF rewrite finished successfully")
+		}
+		aofConfig.Unlock()
+	}()
+	return nil
+}
+
+// 写新的 base 文件，更新 manifest，删除旧文件
+func finishAppendOnlyRewrite(snapshot *storeSnapshot, baseSeq int, oldBaseName string, oldIncrNames []string) error {
+	newBaseName := baseFileName(baseSeq)
+	basePath := filepath.Join(aofDirPath(), newBaseName)
+	tmpPath := fmt.Sprintf("%s.tmp-rewrite-%d", basePath, os.Getpid())
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if strings.HasSuffix(newBaseName, ".rdb") {
+		// RDB 格式可以表示所有类型，加载也更快
+		err = writeRDB(writer, snapshot, true)
+	} else {
+		writeSnapshotCommands(writer, snapshot)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmpPath, basePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	aofConfig.Lock()
+	manifest := aofConfig.manifest
+	manifest.baseName = newBaseName
+	manifest.baseSeq = baseSeq
+	manifest.incrNames = manifest.incrNames[len(oldIncrNames):]
+	err = persistManifest(manifest)
+	aofConfig.currentSize = manifestFilesSize(manifest)
+	aofConfig.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 新 manifest 生效后，旧的 base 和 incr 文件不再需要
+	for _, name := range append([]string{oldBaseName}, oldIncrNames...) {
+		os.Remove(filepath.Join(aofDirPath(), name))
+	}
+	return nil
+}
+
+// 快照中不能用命令重放的类型：从 RDB 载入的列表、集合、有序集合、哈希和消费者组
+// 本服务没有这些类型的写命令（消费者组的 PEL 也无法用命令表示），只能保存在 RDB 格式的 base 文件中
+func unreplayableType(snapshot *storeSnapshot) string {
+	switch {
+	case len(snapshot.lists) > 0:
+		return "lists"
+	case len(snapshot.sets) > 0:
+		return "sets"
+	case len(snapshot.zsets) > 0:
+		return "sorted sets"
+	case len(snapshot.hashes) > 0:
+		return "hashes"
+	}
+	for _, groups := range snapshot.groups {
+		if len(groups) > 0 {
+			return "stream consumer groups"
+		}
+	}
+	return ""
+}
+
+// 把快照写成重建数据所需的最少命令：字符串用 SET，过期时间用 PEXPIREAT，流用 XADD
+// 调用前已经用 unreplayableType 检查过快照中没有其他类型
+func writeSnapshotCommands(writer *bufio.Writer, snapshot *storeSnapshot) {
+	for key, value := range snapshot.data {
+		writer.WriteString(encodeArray([]string{"SET", key, value}))
+	}
+	for stream, entries := range snapshot.streams {
+		for _, entry := range entries {
+			argv := []string{"XADD", stream, entry.ID}
+			for field, value := range entry.Fields {
+				argv = append(argv, field, value)
+			}
+			writer.WriteString(encodeArray(argv))
+		}
+	}
+	for key, expireAt := range snapshot.expires {
+		writer.WriteString(encodeArray([]string{"PEXPIREAT", key, strconv.FormatInt(expireAt, 10)}))
+	}
+}
+
+// INFO persistence 中 AOF 相关的字段
+func aofInfo() string {
+	aofConfig.Lock()
+	defer aofConfig.Unlock()
+	enabled, inProgress := 0, 0
+	if aofConfig.file != nil {
+		enabled = 1
+	}
+	if aofConfig.rewriteInProgress {
+		inProgress = 1
+	}
+	return fmt.Sprintf(
+		"aof_enabled:%d\r\naof_rewrite_in_progress:%d\r\naof_last_rewrite_time_sec:%d\r\naof_last_bgrewrite_status:%s\r\naof_current_size:%d\r\naof_base_size:%d",
+		enabled, inProgress, aofConfig.lastRewriteSeconds, aofConfig.lastRewriteStatus, aofConfig.currentSize, aofConfig.baseSize,
+	)
+}
+
+// 判断是否需要传播（写入 AOF、发给 slave）：写命令且执行成功
+func shouldPropagate(cmd, response string) bool {
+	return isWriteCommand(cmd) && !strings.HasPrefix(response, "-")
+}
+
+// 把写命令改写成确定性的形式，AOF 重放和 slave 执行时得到同样的结果
+// SET 的相对过期时间改成绝对时间 PXAT，XADD 的自动 ID 改成实际生成的 ID
+func deterministicCommand(cmd string, args []string, response string) []string {
+	argv := append([]string{cmd}, args...)
+	switch cmd {
+	case "SET":
+		if len(args) > 3 && strings.ToUpper(args[2]) == "PX" {
+			if expireAt, ok := storeExpireTime(args[0]); ok {
+				argv = []string{"SET", args[0], args[1], "PXAT", strconv.FormatInt(expireAt, 10)}
+			}
+		}
+	case "XADD":
+		if len(args) > 1 && strings.Contains(args[1], "*") {
+			// 响应是 $len\r\nID\r\n
+			lines := strings.Split(response, "\r\n")
+			if len(lines) >= 2 {
+				argv[2] = lines[1]
+			}
+		}
+	}
+	return argv
+}
+
+// 启动时加载 AOF：按 manifest 依次重放 base 和 incr 文件
+// 旧版本的单个 appendonly.aof 会被移动到 AOF 目录中作为 base 文件
+func LoadAOF(dir, filename string) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		legacyPath := filepath.Join(dir, filename)
+		if _, err := os.Stat(legacyPath); err != nil {
+			fmt.Println("AOF not found, starting with an empty dataset:", aofManifestPath())
+			return nil
+		}
+		if err := os.MkdirAll(aofDirPath(), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(legacyPath, filepath.Join(aofDirPath(), filename)); err != nil {
+			return err
+		}
+		manifest = &aofManifest{baseName: filename, baseSeq: 1}
+		if err := persistManifest(manifest); err != nil {
+			return err
+		}
+		fmt.Println("Upgraded legacy AOF file to multi part AOF:", legacyPath)
+	}
+
+	files := append([]string{manifest.baseName}, manifest.incrNames...)
+	total := 0
+	for i, name := range files {
+		// 只有最后一个文件允许尾部不完整
+		loaded, err := loadAppendOnlyFile(filepath.Join(aofDirPath(), name), i == len(files)-1)
+		if err != nil {
+			return err
+		}
+		total += loaded
+	}
+
+	aofConfig.Lock()
+	aofConfig.manifest = manifest
+	aofConfig.Unlock()
+	fmt.Printf("Loaded %d commands from %d AOF files\n", total, len(files))
+	return nil
+}
+
+// 重放单个 AOF 文件，返回执行的命令数
+func loadAppendOnlyFile(filePath string, isLast bool) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) && isLast {
+			return 0, nil // 刚创建还没有写入的 incr 文件
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64      // 已经完整读取的字节数
+	var multiOffset int64 // 当前事务 MULTI 之前的位置
+	var queued [][]string // 事务中排队的命令
+	inMulti := false
+	loaded := 0
+
+	// 以 RDB 前导开头的文件，先载入 RDB 部分，之后如果还有命令继续重放
+	if magic, _ := reader.Peek(5); string(magic) == "REDIS" {
+		stats, err := loadRDB(reader)
+		if err != nil {
+			return 0, fmt.Errorf("loading RDB preamble of %s: %v", filePath, err)
+		}
+		fmt.Printf("Loaded %d keys from the RDB preamble of %s\n", stats.loaded, filepath.Base(filePath))
+		offset = stats.size
+	}
+
+	for {
+		argv, n, err := readRESPCommand(reader)
+		if err == io.EOF && !inMulti {
+			break
+		}
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			// 文件尾部不完整（例如写入时宕机）
+			validOffset := offset
+			if inMulti {
+				validOffset = multiOffset // 未写完的事务整体丢弃
+			}
+			if !isLast || aofConfig.loadTruncated != "yes" {
+				return loaded, fmt.Errorf("unexpected end of AOF file %s at offset %d, set aof-load-truncated yes to load it", filePath, offset)
+			}
+			file.Close()
+			if err := os.Truncate(filePath, validOffset); err != nil {
+				return loaded, fmt.Errorf("error truncating AOF file: %v", err)
+			}
+			fmt.Printf("AOF file %s was truncated, removed the incomplete tail after offset %d\n", filePath, validOffset)
+			break
+		}
+		if err != nil {
+			return loaded, fmt.Errorf("bad AOF format in %s at offset %d: %v", filePath, offset, err)
+		}
+
+		cmd := strings.ToUpper(argv[0])
+		switch {
+		case cmd == "MULTI":
+			inMulti = true
+			multiOffset = offset
+			queued = nil
+		case cmd == "EXEC" && inMulti:
+			for _, queuedArgv := range queued {
+				replayCommand(queuedArgv)
+			}
+			inMulti = false
+			queued = nil
+		case inMulti:
+			queued = append(queued, argv)
+		default:
+			replayCommand(argv)
+		}
+		offset += int64(n)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// 重放一条 AOF 中的命令
+func replayCommand(argv []string) {
+	cmd := strings.ToUpper(argv[0])
+	handler, exists := commandHandlers[cmd]
+	if !exists {
+		fmt.Println("Unknown command in AOF:", cmd)
+		return
+	}
+	response := handler(argv[1:])
+	if strings.HasPrefix(response, "-") {
+		fmt.Println("Error replaying AOF command:", argv, strings.TrimSpace(response))
+	}
+}
+
+// 严格按照 RESP 格式读取一条命令，返回参数和读取的字节数（AOF、DEBUG IMPORT、master 的复制流）
+// 在命令边界遇到文件结束返回 io.EOF，命令不完整返回 io.ErrUnexpectedEOF，解析见 internal/resp
+func readRESPCommand(reader *bufio.Reader) ([]string, int, error) {
+	return resp.ReadCommand(reader, requestLimits(true))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/resp"
+)
+
+// 不使用 RDB 格式重写 AOF 后重放，字符串和流的数据和过期时间都要保留
+func TestRewriteWithoutPreambleKeepsExpires(t *testing.T) {
+	initStore()
+	clientConfig.maxBulkLen = resp.DefaultMaxBulk
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	ms := strconv.FormatInt(expireAt, 10)
+	for _, argv := range [][]string{
+		{"SET", "str", "v"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"XADD", "persistent", "1-1", "f", "v"},
+		{"PEXPIREAT", "str", ms},
+		{"PEXPIREAT", "stream", ms},
+	} {
+		if reply := commandHandlers[argv[0]](argv[1:]); reply[0] == '-' {
+			t.Fatalf("%v: %q", argv, reply)
+		}
+	}
+
+	snapshot := snapshotStore()
+	if kind := unreplayableType(snapshot); kind != "" {
+		t.Fatalf("snapshot reported as unreplayable: %s", kind)
+	}
+	path := filepath.Join(t.TempDir(), "appendonly.aof.1.base.aof")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := bufio.NewWriter(file)
+	writeSnapshotCommands(writer, snapshot)
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	storeFlush()
+	if _, err := loadAppendOnlyFile(path, true); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"str": "string", "stream": "stream", "persistent": "stream"} {
+		if got := storeType(key); got != want {
+			t.Errorf("type of %s after replay = %s, want %s", key, got, want)
+		}
+	}
+	for _, key := range []string{"str", "stream"} {
+		if got, ok := storeExpireTime(key); !ok || got != expireAt {
+			t.Errorf("expire time of %s after replay = %d (%v), want %d", key, got, ok, expireAt)
+		}
+	}
+	if _, ok := storeExpireTime("persistent"); ok {
+		t.Errorf("persistent key got an expire time after replay")
+	}
+}
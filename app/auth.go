@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+)
+
+// 认证配置
+var authConfig = struct {
+	requirepass string // default 用户的密码，空表示不需要认证（用户和权限见 acl.go）
+	masterauth  string // 作为 slave 连接有密码的 master 时使用的密码
+	masteruser  string // 作为 slave 连接 master 时使用的用户名，空表示 default
+}{}
+
+// 需要认证时客户端能执行的命令（QUIT 在读取循环中直接处理）
+func allowedBeforeAuth(cmd string) bool {
+	return cmd == "AUTH" || cmd == "HELLO"
+}
+
+// 连接是否已经通过认证
+func (c *Client) isAuthenticated() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.authenticated
+}
+
+// 以用户身份登录
+func (c *Client) setUser(user *aclUser) {
+	c.Lock()
+	c.user = user
+	c.authenticated = true
+	c.Unlock()
+}
+
+// 处理 AUTH [username] password
+func handleAUTH(client *Client, args []string) string {
+	var username, password string
+	switch len(args) {
+	case 1:
+		if _, nopass := defaultUser(); nopass {
+			return "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"
+		}
+		username, password = "default", args[0]
+	case 2:
+		username, password = args[0], args[1]
+	default:
+		return "-ERR wrong number of arguments for 'auth' command\r\n"
+	}
+	user, ok := authenticateUser(username, password)
+	if !ok {
+		addACLLogEntry("auth", "toplevel", "AUTH", username, client)
+		return "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+	}
+	client.setUser(user)
+	return "+OK\r\n"
+}
+
+// 日志中隐藏 AUTH、HELLO AUTH 的密码
+func redactArgs(cmd string, args []string) []string {
+	switch cmd {
+	case "AUTH":
+		return []string{"(redacted)"}
+	case "HELLO":
+		for i, arg := range args {
+			if strings.ToUpper(arg) == "AUTH" {
+				return append(append([]string{}, args[:i+1]...), "(redacted)")
+			}
+		}
+	}
+	return args
+}
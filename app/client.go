@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 客户端连接状态，每个连接一个
+// 内嵌 net.Conn，可以直接传给事务等只需要 conn 的方法
+type Client struct {
+	net.Conn
+	sync.Mutex                    // 保护 protocol、subscriptions 等会被其他协程读取的状态
+	ID            int64           // 全局唯一的客户端 ID
+	Name          string          // CLIENT SETNAME 设置的名字
+	protocol      int             // RESP 协议版本，2 或 3
+	subscriptions map[string]bool // 订阅的频道
+	tracking      trackingState   // 客户端缓存（CLIENT TRACKING）状态
+	isReplica     bool            // 发送过 PSYNC 的副本连接，使用 replica 类的输出缓冲区限制
+	authenticated bool            // 已经通过 AUTH 认证，或者不需要认证
+	user          *aclUser        // 登录的 ACL 用户，新连接是 default
+	repl          replicaState    // 作为 slave 连接时的复制状态，由 config 锁保护，见 replication.go
+
+	// MULTI 事务状态，见 trancation.go
+	inTransaction    bool
+	transactionQueue [][]string
+
+	// 输出缓冲区，其他协程（失效通知、发布消息、命令传播）也会写这个连接，见 output.go
+	outMu          sync.Mutex
+	outCond        *sync.Cond
+	drained        *sync.Cond    // 写协程取走输出缓冲区的数据或者退出时广播，见 writePayload
+	output         []byte        // 等待发送的数据
+	sending        int           // 写协程正在写入 socket 的字节数
+	flushPending   bool          // 需要唤醒写协程发送
+	closing        bool          // 连接正在关闭，不再接受新的输出
+	holding        bool          // 暂停发送新的输出，见 holdOutput
+	held           []byte        // 暂停期间追加的输出
+	softLimitSince time.Time     // 开始超过软限制的时间，零值表示没有超过
+	writerDone     chan struct{} // 写协程退出时关闭
+}
+
+// 客户端命令处理函数类型，需要知道是哪个连接发来的
+type clientCommandHandler func(client *Client, args []string) string
+
+// 需要连接状态的命令映射，分发时优先于 commandHandlers
+var clientCommandHandlers = map[string]clientCommandHandler{
+	"AUTH":        handleAUTH,        // 添加 AUTH 命令
+	"ACL":         handleACL,         // 添加 ACL 命令
+	"CLIENT":      handleCLIENT,      // 添加 CLIENT 命令
+	"HELLO":       handleHELLO,       // 添加 HELLO 命令，切换 RESP 协议
+	"SUBSCRIBE":   handleSUBSCRIBE,   // 添加 SUBSCRIBE 命令
+	"UNSUBSCRIBE": handleUNSUBSCRIBE, // 添加 UNSUBSCRIBE 命令
+}
+
+// 所有在线客户端，按 ID 索引（REDIRECT 需要按 ID 找到目标连接）
+var clients = struct {
+	sync.RWMutex
+	byID map[int64]*Client
+}{byID: make(map[int64]*Client)}
+
+var nextClientID int64
+
+// 创建并登记一个客户端
+func newClient(conn net.Conn) *Client {
+	client := &Client{
+		Conn:          conn,
+		ID:            atomic.AddInt64(&nextClientID, 1),
+		protocol:      2,
+		subscriptions: make(map[string]bool),
+		writerDone:    make(chan struct{}),
+		repl:          replicaState{ackAOFOffset: -1},
+	}
+	client.user, client.authenticated = defaultUser()
+	client.outCond = sync.NewCond(&client.outMu)
+	client.drained = sync.NewCond(&client.outMu)
+	go client.writeLoop()
+	clients.Lock()
+	clients.byID[client.ID] = client
+	clients.Unlock()
+	return client
+}
+
+// 按 ID 查找客户端
+func lookupClient(id int64) (*Client, bool) {
+	clients.RLock()
+	defer clients.RUnlock()
+	client, exists := clients.byID[id]
+	return client, exists
+}
+
+// 连接断开时释放客户端：发送完剩余的回复，取消订阅、关闭 tracking 并注销
+func (c *Client) release() {
+	c.closeOutput()
+	removeReplica(c)
+	unsubscribeAll(c)
+	disableTracking(c)
+	clients.Lock()
+	delete(clients.byID, c.ID)
+	clients.Unlock()
+}
+
+// 当前使用的 RESP 协议版本
+func (c *Client) respVersion() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.protocol
+}
+
+// 是否处于订阅模式（RESP2 下只能执行订阅相关命令）
+func (c *Client) inPubSubMode() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.protocol == 2 && len(c.subscriptions) > 0
+}
+
+// 订阅模式下允许执行的命令
+func isPubSubCommand(cmd string) bool {
+	switch cmd {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PING", "QUIT", "RESET":
+		return true
+	}
+	return false
+}
+
+// 处理 CLIENT 命令
+func handleCLIENT(client *Client, args []string) string {
+	if len(args) < 1 {
+		return "-ERR wrong number of arguments for 'client' command\r\n"
+	}
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		return fmt.Sprintf(":%d\r\n", client.ID)
+	case "SETNAME":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'client|setname' command\r\n"
+		}
+		if strings.ContainsAny(args[1], " \n") {
+			return "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"
+		}
+		client.Name = args[1]
+		return "+OK\r\n"
+	case "GETNAME":
+		if client.Name == "" {
+			return "$-1\r\n"
+		}
+		return encodeBulkString(client.Name)
+	case "TRACKING":
+		return handleClientTracking(client, args[1:])
+	case "CACHING":
+		return handleClientCaching(client, args[1:])
+	case "GETREDIR":
+		return fmt.Sprintf(":%d\r\n", trackingRedirection(client))
+	case "TRACKINGINFO":
+		return trackingInfo(client)
+	}
+	return "-ERR unknown subcommand '" + args[0] + "'. Try CLIENT HELP.\r\n"
+}
+
+// 处理 HELLO [protover [AUTH username password] [SETNAME clientname]]
+func handleHELLO(client *Client, args []string) string {
+	protocol := client.protocol
+	authenticated := client.isAuthenticated()
+	var user *aclUser
+	var name string
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "-ERR Protocol version is not an integer or out of range\r\n"
+		}
+		if ver < 2 || ver > 3 {
+			return "-NOPROTO unsupported protocol version\r\n"
+		}
+		protocol = ver
+		for i := 1; i < len(args); i++ {
+			option := strings.ToUpper(args[i])
+			if option == "AUTH" && i+2 < len(args) {
+				var ok bool
+				if user, ok = authenticateUser(args[i+1], args[i+2]); !ok {
+					addACLLogEntry("auth", "toplevel", "AUTH", args[i+1], client)
+					return "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+				}
+				authenticated = true
+				i += 2
+				continue
+			}
+			if option == "SETNAME" && i+1 < len(args) {
+				name = args[i+1]
+				i++
+				continue
+			}
+			return "-ERR Syntax error in HELLO option '" + args[i] + "'\r\n"
+		}
+	}
+	if !authenticated {
+		return "-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n"
+	}
+	if name != "" {
+		client.Name = name
+	}
+	if user != nil {
+		client.setUser(user)
+	}
+	client.Lock()
+	client.protocol = protocol
+	client.Unlock()
+
+	fields := []string{
+		encodeBulkString("server"), encodeBulkString("redis"),
+		encodeBulkString("version"), encodeBulkString("7.2.0"),
+		encodeBulkString("proto"), fmt.Sprintf(":%d\r\n", protocol),
+		encodeBulkString("id"), fmt.Sprintf(":%d\r\n", client.ID),
+		encodeBulkString("mode"), encodeBulkString("standalone"),
+		encodeBulkString("role"), encodeBulkString(getRole()),
+		encodeBulkString("modules"), "*0\r\n",
+	}
+	if protocol == 3 {
+		return fmt.Sprintf("%%%d\r\n%s", len(fields)/2, strings.Join(fields, ""))
+	}
+	return fmt.Sprintf("*%d\r\n%s", len(fields), strings.Join(fields, ""))
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/hex"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/resp"
 )
 
 // 命令处理函数类型
@@ -18,18 +19,30 @@ var commandHandlers = map[string]commandHandler{
 	"PING":     handlePING,
 	"SET":      handleSET,
 	"GET":      handleGET,
+	"DEL":      handleDEL,      // 添加 DEL 命令，master 删除过期 key 时也传播 DEL
 	"TYPE":     handleType,
 	"ECHO":     handleECHO,
 	"CONFIG":   handleCONFIG,   // CONFIG GET 命令先以CONFIG处理
 	"KEYS":     handleKEYS,     // 添加 KEYS 命令
 	"SAVE":     handleSAVE,     // 添加 SAVE 命令
+	"BGSAVE":   handleBGSAVE,   // 添加 BGSAVE 命令
+	"LASTSAVE": handleLASTSAVE, // 添加 LASTSAVE 命令
 	"INFO":     handleInfo,     // 添加 INFO 命令
 	"REPLCONF": handleREPLCONF, // 添加 REPLCONF 命令
-	"PSYNC":    handlePSYNC,    // 添加 PSYNC 命令处理
+	// "PSYNC" 需要连接，在 processCommand 中处理，见 replication.go
 	"XADD":     handleXADD,     // 添加 XADD 命令处理
 	"XRANGE":   handleXRANGE,   // 添加 XRANGE 命令处理
 	"XREAD":	handleXREAD,		// 添加 XREAD 命令处理
 	"INCR":     handleINCR,     // 添加 INCR 命令处理
+	"PUBLISH":  handlePUBLISH,  // 添加 PUBLISH 命令处理
+	"PEXPIREAT": handlePEXPIREAT, // 添加 PEXPIREAT 命令处理
+	"WAIT":     handleWAIT,     // 添加 WAIT 命令处理
+	"WAITAOF":  handleWAITAOF,  // 添加 WAITAOF 命令处理
+	"BGREWRITEAOF": handleBGREWRITEAOF, // 添加 BGREWRITEAOF 命令处理
+	"MSET":     handleMSET,     // 添加 MSET 命令处理
+	"RENAME":   handleRENAME,   // 添加 RENAME 命令处理
+	// "DEBUG" 在 debug.go 的 init 中注册（导入时要查 commandHandlers，直接写在这里会形成初始化循环）
+	// "REPLICAOF"、"SLAVEOF" 在 replication.go 的 init 中注册（同样的原因：slave 执行 master 的命令要查 commandHandlers）
 	// "MULTI":	handleMULTI,		// 添加 MULTI 命令处理
 	// "EXEC":		handleEXEC,		// 添加 EXEC 命令处理
 }
@@ -56,58 +69,15 @@ var commandHandlers = map[string]commandHandler{
 // 	return "", nil, nil
 // }
 
-// 解析 RESP 协议
-func parseRESP(reader *bufio.Reader) (string, []string, error) {
-	line, err := reader.ReadString('\n')
+// 解析 RESP 协议：批量字符串按声明的长度读取，值中可以包含任意字节（redis-cli --pipe 批量导入）
+// 不以 * 开头的行按内联命令处理（telnet 等），空行跳过；参数个数和长度超过限制时返回协议错误
+func parseRESP(reader *bufio.Reader, authenticated bool) (string, []string, error) {
+	args, err := resp.ReadRequest(reader, requestLimits(authenticated))
 	if err != nil {
 		return "", nil, err
 	}
-	line = strings.TrimSpace(line)
-	if getRole() == "master" {
-		if strings.HasPrefix(line, "*") {
-			count := 0
-			fmt.Sscanf(line, "*%d", &count)
-			var args []string
-			totalBytes := len(line) // `*N\r\n` 的长度
-
-			for i := 0; i < count; i++ {
-				lenLine, _ := reader.ReadString('\n') // 读取 `$N\r\n`
-				totalBytes += len(lenLine) // 计算 `$N\r\n` 长度
-
-				arg, _ := reader.ReadString('\n') // 读取参数值
-				args = append(args, strings.TrimSpace(arg))
-				totalBytes += len(arg) // `VALUE\r\n`
-			}
-
-			// **增加 offset**
-			toSlaveCommands := []string{"SET", "REPLCONF"}
-			for _, scmd := range toSlaveCommands {
-				if strings.ToUpper(args[0]) == scmd {
-					config.IncrementOffset(int64(totalBytes))
-				}
-			}
-
-			return strings.ToUpper(args[0]), args[1:], nil
-		}
-		return "", nil, nil
-	}else{	//master不需要考虑offset
-		if strings.HasPrefix(line, "*") {
-			count := 0
-			fmt.Sscanf(line, "*%d", &count)
-			var args []string
-			for i := 0; i < count; i++ {
-				reader.ReadString('\n') // 读取 $N
-				arg, _ := reader.ReadString('\n')
-				args = append(args, strings.TrimSpace(arg))
-			}
-			
-			return strings.ToUpper(args[0]), args[1:], nil
-		}
-		return "", nil, nil
-	}		
-}	
-	
-
+	return strings.ToUpper(args[0]), args[1:], nil
+}
 
 // 处理 CONFIG 命令
 func handleCONFIG(args []string) string {
@@ -125,6 +95,68 @@ func handleCONFIG(args []string) string {
 		value = rdbConfig.dir
 	case "dbfilename":
 		value = rdbConfig.dbfilename
+	case "save":
+		value = rdbConfig.save
+	case "appendonly":
+		value = aofConfig.appendonly
+	case "appendfilename":
+		value = aofConfig.filename
+	case "appendfsync":
+		value = aofConfig.fsync
+	case "aof-load-truncated":
+		value = aofConfig.loadTruncated
+	case "appenddirname":
+		value = aofConfig.dirname
+	case "auto-aof-rewrite-percentage":
+		value = strconv.Itoa(aofConfig.rewritePercentage)
+	case "auto-aof-rewrite-min-size":
+		value = aofConfig.rewriteMinSizeFlag
+	case "aof-use-rdb-preamble":
+		value = aofConfig.useRDBPreamble
+	case "proto-max-bulk-len":
+		value = strconv.FormatInt(requestLimits(true).MaxBulk, 10)
+	case "client-output-buffer-limit":
+		value = outputBufferLimitConfig()
+	case "single-threaded":
+		value = executor.mode
+	case "keyspace-shards":
+		value = strconv.Itoa(store.count)
+	case "requirepass":
+		value = authConfig.requirepass
+	case "masterauth":
+		value = authConfig.masterauth
+	case "masteruser":
+		value = authConfig.masteruser
+	case "aclfile":
+		value = acl.file
+	case "replica-read-only", "slave-read-only":
+		value = config.ReplicaReadOnly
+	case "repl-diskless-sync":
+		value = config.ReplDisklessSync
+	case "repl-diskless-sync-delay":
+		value = strconv.Itoa(config.ReplDisklessSyncDelay)
+	case "repl-diskless-load":
+		value = config.ReplDisklessLoad
+	case "repl-backlog-size":
+		config.Lock()
+		value = strconv.Itoa(len(config.backlog.buf))
+		config.Unlock()
+	case "unixsocket":
+		value = config.UnixSocket
+	case "unixsocketperm":
+		value = config.UnixSocketPerm
+	case "tls-port":
+		value = strconv.Itoa(tlsConfig.port)
+	case "tls-cert-file":
+		value = tlsConfig.certFile
+	case "tls-key-file":
+		value = tlsConfig.keyFile
+	case "tls-ca-cert-file":
+		value = tlsConfig.caCertFile
+	case "tls-auth-clients":
+		value = tlsConfig.authClients
+	case "tls-replication":
+		value = tlsConfig.replication
 	default:
 		rdbConfig.RUnlock()
 		return "$-1\r\n" // 未知配置项
@@ -134,11 +166,79 @@ func handleCONFIG(args []string) string {
 	return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(value), value)
 }
 
+// 执行一条命令，写命令执行成功后传播（追加到 AOF、发给 slave）
+// 执行和传播期间持有 writeGate 读锁，AOF 重写切换文件、全量同步生成快照时不会落在两者之间
+// 读命令执行期间持有命令访问的分片的事务锁（读锁），和 EXEC 互斥；阻塞命令等待时不能持有，否则会挡住唤醒它的写命令
+// 写命令独占所在分片的事务锁，同一个 key 的写命令执行和传播的顺序相同，slave 和 AOF 重放得到同样的结果
+// 命令访问到的过期 key 由 master 删除并传播 DEL：写命令在执行之前，读命令在执行之后（见 expire.go）
+func callCommand(handler commandHandler, cmd string, args []string) string {
+	keys := commandKeys(cmd, args)
+	if !isWriteCommand(cmd) {
+		if isBlockingCommand(cmd, args) {
+			return handler(args)
+		}
+		leave := enterShardGates(keys)
+		response := handler(args)
+		leave()
+		expireReadKeys(keys)
+		return response
+	}
+	unlockGates := lockShardGates(keys)
+	defer unlockGates()
+	writeGate.RLock()
+	defer writeGate.RUnlock()
+	propagateExpired(keys)
+	response := handler(args)
+	if shouldPropagate(cmd, response) {
+		propagateWrites([][]string{deterministicCommand(cmd, args, response)})
+	}
+	return response
+}
+
+// 命令访问的 key，用于对所在的分片加锁
+func commandKeys(cmd string, args []string) []string {
+	switch cmd {
+	case "DEL":
+		return args
+	case "SET", "INCR", "XADD", "PEXPIREAT":
+		if len(args) > 0 {
+			return args[:1]
+		}
+		return nil
+	case "RENAME":
+		if len(args) >= 2 {
+			return args[:2]
+		}
+		return nil
+	case "MSET":
+		var keys []string
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return keys
+	}
+	return readCommandKeys(cmd, args)
+}
+
 // 处理 PING
 func handlePING(args []string) string {
 	return "+PONG\r\n"
 }
 
+// 处理 DEL key [key ...]，返回删除的 key 数
+func handleDEL(args []string) string {
+	if len(args) < 1 {
+		return "-ERR wrong number of arguments for 'del' command\r\n"
+	}
+	deleted := 0
+	for _, key := range args {
+		if storeDelete(key) {
+			deleted++
+		}
+	}
+	return fmt.Sprintf(":%d\r\n", deleted)
+}
+
 // 处理 ECHO
 func handleECHO(args []string) string {
 	if len(args) < 1 {
@@ -166,15 +266,54 @@ func handleSET(args []string) string {
 		}
 	}
 
+	// 处理可选参数 PXAT（绝对过期时间，AOF 中使用）
+	if len(args) > 2 && strings.ToUpper(args[2]) == "PXAT" && len(args) > 3 {
+		if pxat, err := strconv.ParseInt(args[3], 10, 64); err == nil {
+			ttl = pxat
+		} else {
+			return "-ERR PXAT argument must be an integer\r\n"
+		}
+	}
+
 	storeSet(key, value, ttl)
-	// 发送给所有 slave 节点
-	if getRole() == "master" {
-		propagateToSlaves(fmt.Sprintf("*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
-			len(key), key, len(value), value))
+	return "+OK\r\n"
+}
+
+// 处理 MSET key value [key value ...]，所有 key 一起设置
+func handleMSET(args []string) string {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return "-ERR wrong number of arguments for 'mset' command\r\n"
 	}
+	storeMSet(args)
 	return "+OK\r\n"
 }
 
+// 处理 RENAME key newkey
+func handleRENAME(args []string) string {
+	if len(args) != 2 {
+		return "-ERR wrong number of arguments for 'rename' command\r\n"
+	}
+	if !storeRename(args[0], args[1]) {
+		return "-ERR no such key\r\n"
+	}
+	return "+OK\r\n"
+}
+
+// 处理 PEXPIREAT key unix-time-milliseconds
+func handlePEXPIREAT(args []string) string {
+	if len(args) != 2 {
+		return "-ERR wrong number of arguments for 'pexpireat' command\r\n"
+	}
+	expireAt, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return "-ERR value is not an integer or out of range\r\n"
+	}
+	if !storeSetExpire(args[0], expireAt) {
+		return ":0\r\n"
+	}
+	return ":1\r\n"
+}
+
 // 处理 GET
 func handleGET(args []string) string {
 	if len(args) < 1 {
@@ -204,22 +343,21 @@ func handleType(args []string) string {
 	// 获取传入的键
 	key := args[0]
 
-	// 检查键是否存在于 store.data 或 store.streams 中
-	store.RLock() // 使用读锁
-	defer store.RUnlock()
-
-	// 检查键是否在 streams 中，表示是 stream 类型
-	if _, exists := store.streams[key]; exists {
+	// 检查键在 store 中的类型
+	switch valueType := storeType(key); valueType {
+	case "stream":
+		// 检查键是否在 streams 中，表示是 stream 类型
 		return "+stream\r\nstream"
-	}
-
-	// 检查键是否在 data 中，表示是 string 类型
-	if _, exists := store.data[key]; exists {
+	case "string":
+		// 检查键是否在 data 中，表示是 string 类型
 		return "+string\r\nstring"
+	case "none":
+		// 键不存在时，返回 "none"
+		return "+none\r\nnone"
+	default:
+		// 从 RDB 载入的列表、集合、有序集合和哈希
+		return "+" + valueType + "\r\n"
 	}
-
-	// 键不存在时，返回 "none"
-	return "+none\r\nnone"
 }
 
 // 处理 KEYS 命令，添加规则匹配
@@ -260,37 +398,44 @@ func handleSAVE(args []string) string {
 	return "+OK\r\n"
 }
 
+// 处理 BGSAVE [SCHEDULE] 命令
+func handleBGSAVE(args []string) string {
+	if len(args) > 1 || (len(args) == 1 && strings.ToUpper(args[0]) != "SCHEDULE") {
+		return "-ERR syntax error\r\n"
+	}
+	if err := BgsaveRDB(rdbConfig.dir, rdbConfig.dbfilename); err != nil {
+		return "-ERR " + err.Error() + "\r\n"
+	}
+	return "+Background saving started\r\n"
+}
+
+// 处理 LASTSAVE 命令，返回上次成功保存的 Unix 时间戳
+func handleLASTSAVE(args []string) string {
+	rdbConfig.RLock()
+	defer rdbConfig.RUnlock()
+	return fmt.Sprintf(":%d\r\n", rdbConfig.lastSave.Unix())
+}
+
 // 处理 INFO replication 命令
 func handleInfo(args []string) string {
 	if len(args) > 0 && strings.ToLower(args[0]) == "replication" {
 		// RESP Bulk String 响应格式
-		response := fmt.Sprintf(
-			"role:%s\r\nmaster_replid:%s\r\nmaster_repl_offset:%d",
-			getRole(),
-			config.MasterReplID,
-			config.ReplOffset,
-		)
+		response := replicationInfo()
 		return fmt.Sprintf("$%d\r\n%s\r\n", len(response), response)
 	}
+	if len(args) > 0 && strings.ToLower(args[0]) == "persistence" {
+		return encodeBulkString(persistenceInfo())
+	}
 	return "-ERR invalid INFO section\r\n"
 }
 
 // 处理 REPLCONF 命令
 func handleREPLCONF(args []string) string {
 	if len(args) >= 2 {
-		if args[0] == "listening-port" {
-			// 对应 REPLCONF listening-port（master接受）
-			return "+OK\r\n"
-		} else if args[0] == "capa" && args[1] == "psync2" {
-			// 对应 REPLCONF capa psync2（master接受）
-			return "+OK\r\n"
-		} else if args[0] == "getack" && args[1] == "*" {
-			// 处理 REPLCONF GETACK *(slave接受后返回)
-			offsetStr := strconv.FormatInt(config.ReplOffset, 10)	//将 int64 转换为 10 进制字符串。
-			return fmt.Sprintf("*3\r\n$8\r\nREPLCONF\r\n$3\r\nACK\r\n$%d\r\n%s\r\n", len(offsetStr), offsetStr)
-		}else if args[0] == "ACK" {
-			// 处理 REPLCONF ACK <REPL_ID> <OFFSET>（master接受后打印就行，不操作，后面在server里加上net信息）
-			return fmt.Sprintf("*3\r\n$3\r\nACK\r\n$2\r\nis\r\n$%d\r\n%s\r\n", len(args[1]), args[1])
+		// REPLCONF listening-port、capa、ACK 由 master 在 processCommand 中处理，见 replication.go
+		if strings.ToUpper(args[0]) == "GETACK" && args[1] == "*" {
+			// 处理 REPLCONF GETACK *(slave接受后返回 REPLCONF ACK <offset> FACK <aofoffset>)
+			return replconfAck()
 		} else {
 			return "-ERR unknown REPLCONF command 's args\r\n"
 		}
@@ -298,36 +443,10 @@ func handleREPLCONF(args []string) string {
 	return "-ERR invalid REPLCONF command\r\n"
 }
 
-// 处理 PSYNC 命令
-func handlePSYNC(args []string) string {
-	// 当收到 PSYNC ? -1 请求时，返回 FULLRESYNC <REPL_ID> 0
-	if len(args) == 2 && args[0] == "?" && args[1] == "-1" {
-		// 1. 发送 FULLRESYNC 响应
-		fullResyncResponse := fmt.Sprintf("+FULLRESYNC %s 0\r\n", config.MasterReplID)
-
-		// 2. 空 RDB 文件（Hex 格式）
-		emptyRDBHex := "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
-		emptyRDB, _ := hex.DecodeString(emptyRDBHex)
-		fmt.Println("emptyRDB is:", len(emptyRDB), string(emptyRDB))
-		// 3. 使用 bytes.Buffer 来处理二进制数据
-		var buffer bytes.Buffer
-
-		// 4. 向缓冲区写入 FULLRESYNC 响应和 RDB 文件响应
-		buffer.WriteString(fullResyncResponse)                    // 写入 FULLRESYNC 响应
-		buffer.WriteString(fmt.Sprintf("$%d\r\n", len(emptyRDB))) // 写入长度信息
-		buffer.Write(emptyRDB)                                    // 写入空的 RDB 数据
-
-		// 返回完整响应
-		return buffer.String()
-
-	}
-	return "-ERR invalid PSYNC command\r\n"
-}
-
 // 解析 XADD 命令
 func handleXADD(args []string) string {
 	if len(args) < 3 || len(args)%2 == 1 {
-		return "-ERR wrong number of arguments for 'XADD' command"
+		return "-ERR wrong number of arguments for 'XADD' command\r\n"
 	}
 	
 	stream := args[0]
@@ -340,6 +459,9 @@ func handleXADD(args []string) string {
 
 	 // 假设 xadd 函数返回流的 ID
 	 result := xadd(stream, id, fields)
+	 if strings.HasPrefix(result, "-") {
+		 return result // ID 不合法，没有添加条目
+	 }
 
 	// 通知所有等待 `XREAD` 的客户端
 	notifyClients(stream) 
@@ -392,10 +514,11 @@ func handleXRANGE(args []string) string {
 	startTS, startSeq := parseStreamID(startID)
 	endTS, endSeq := parseStreamID(endID)
 
-	store.RLock()
-	defer store.RUnlock()
+	shard := shardFor(streamKey)
+	shard.RLock()
+	defer shard.RUnlock()
 
-	entries, exists := store.streams[streamKey]
+	entries, exists := shard.streams[streamKey]
 	if !exists {
 		return "*0\r\n" // 空列表
 	}
@@ -431,148 +554,235 @@ func handleXRANGE(args []string) string {
 }
 
 
-// 处理XREAD命令
-var waitingClients = make(map[string][]chan struct{})
+// 阻塞在 XREAD 上等待新条目的客户端，按流的 key 索引
+// 使用单独的锁：XREAD 在持有分片读锁时登记（检查数据和登记之间不会漏掉 XADD），
+// XADD 在释放分片锁之后通知，锁的顺序始终是分片 -> waitingClients
+var waitingClients = struct {
+	sync.Mutex
+	byKey map[string][]chan struct{}
+}{byKey: make(map[string][]chan struct{})}
+
+// 解析后的 XREAD 请求，$ 已经换成当时流的最新 ID
+type xreadRequest struct {
+	keys     []string          // 按参数顺序
+	ids      map[string]string // 流 -> 已读到的 ID
+	blocking bool
+	timeout  time.Duration // 0 表示无限阻塞
+}
 
+// 处理XREAD命令
 func handleXREAD(args []string) string {
-    if len(args) < 3 {
-        return "-ERR syntax error\r\n"
-    }
+	return xread(args, func(fn func()) { fn() })
+}
 
-    var blockTime int
-    blocking := false
-    infiniteBlock := false
-
-    // 解析 BLOCK 参数
-    if args[0] == "block" {
-        if len(args) < 5 {
-            return "-ERR syntax error\r\n"
-        }
-        blocking = true
-        var err error
-        blockTime, err = strconv.Atoi(args[1])
-        if err != nil || blockTime < 0 {
-            return "-ERR invalid block time\r\n"
-        }
-        if blockTime == 0 {
-            infiniteBlock = true
-        }
-        args = args[2:] // 移除 BLOCK 参数
-    }
+// 事务中的 XREAD：忽略 BLOCK，没有新条目时立即返回 NULL（和 Redis 一样，事务中的命令不阻塞）
+// EXEC 持有涉及分片的事务锁，在锁内等待会让写这个流的 XADD 永远拿不到锁
+func nonBlockingXREAD(args []string) string {
+	req, reply := parseXREAD(args)
+	if req == nil {
+		return reply
+	}
+	req.blocking = false
+	if reply, _ = req.tryRead(); reply != "" {
+		return reply
+	}
+	return "$-1\r\n"
+}
 
-    // 确保 `streams` 关键字正确
-    if args[0] != "streams" || len(args) < 3 || len(args)%2 != 1 {
-        return "-ERR syntax error\r\n"
-    }
+// 单线程模式下阻塞的 XREAD：读取和登记在执行协程中进行，等待在连接自己的协程中进行，不会阻塞执行协程
+func blockingXREAD(args []string) string {
+	return xread(args, runOnExecutor)
+}
 
-    // // 解析流及其起始 ID
-    // streams := make(map[string]string)
-    // for i := 1; i < len(args); i += 2 {
-    //     streams[args[i]] = args[i+1]
-    // }
-
-	//解析流及其起始 ID以支持$
-    streams := make(map[string]string)
-    store.RLock()
-    for i := 1; i < len(args); i += 2 {
-        streamKey := args[i]
-        lastReadID := args[i+1]
-
-        // 处理 `$` 作为 ID，获取当前流的最新 ID
-        if lastReadID == "$" {
-            if entries, exists := store.streams[streamKey]; exists && len(entries) > 0 {
-                lastReadID = entries[len(entries)-1].ID
-            } else {
-                lastReadID = "0-0" // 若流为空，则等待新条目
-            }
-        }
-        streams[streamKey] = lastReadID
-    }
-    store.RUnlock()
-
-
-
-    for {
-        store.RLock()
-        result := make([]string, 0)
-
-        for streamKey, lastReadID := range streams {
-            if !strings.Contains(lastReadID, "-") {
-                lastReadID += "-0" // 确保 ID 格式正确
-            }
-            lastTS, lastSeq := parseStreamID(lastReadID)
-
-            entries, exists := store.streams[streamKey]
-            if !exists {
-                continue
-            }
-
-            streamResult := []string{fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(streamKey), streamKey)}
-            entryCount := 0
-            entryData := make([]string, 0)
-
-            for _, entry := range entries {
-                ts, seq := parseStreamID(entry.ID)
-                if ts > lastTS || (ts == lastTS && seq > lastSeq) {
-                    entryCount++
-                    entryPart := []string{fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(entry.ID), entry.ID)}
-                    fieldCount := len(entry.Fields) * 2
-                    entryPart = append(entryPart, fmt.Sprintf("*%d\r\n", fieldCount))
-                    for k, v := range entry.Fields {
-                        entryPart = append(entryPart, fmt.Sprintf("$%d\r\n%s\r\n", len(k), k))
-                        entryPart = append(entryPart, fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
-                    }
-                    entryData = append(entryData, strings.Join(entryPart, ""))
-                }
-            }
-
-            if entryCount > 0 {
-                streamResult = append(streamResult, fmt.Sprintf("*%d\r\n%s", entryCount, strings.Join(entryData, "")))
-                result = append(result, strings.Join(streamResult, ""))
-            }
-        }
-        store.RUnlock()
-
-        if len(result) > 0 {
-            return fmt.Sprintf("*%d\r\n%s", len(result), strings.Join(result, ""))
-        }
-
-        if !blocking {
-            return "$-1\r\n"
-        }
-
-        // 使用 channel 等待新数据
-        waitChan := make(chan struct{})
-        store.Lock()
-        for streamKey := range streams {
-            waitingClients[streamKey] = append(waitingClients[streamKey], waitChan)
-        }
-        store.Unlock()
-
-        if infiniteBlock {
-            <-waitChan // 无限阻塞，直到新数据到来
-        } else {
-            select {
-            case <-waitChan:
-                // 有数据更新
-            case <-time.After(time.Duration(blockTime) * time.Millisecond):
-                // 超时返回 NULL
-                return "$-1\r\n"
-            }
-        }
-    }
+// 单线程模式下在连接自己的协程中执行阻塞命令：WAIT、WAITAOF 不访问 keyspace，直接等待
+func runBlockingCommand(cmd string, args []string) string {
+	switch cmd {
+	case "WAIT":
+		return handleWAIT(args)
+	case "WAITAOF":
+		return handleWAITAOF(args)
+	}
+	return blockingXREAD(args)
+}
+
+// 执行 XREAD，访问 keyspace 的部分通过 run 运行
+func xread(args []string, run func(func())) string {
+	var req *xreadRequest
+	var reply string
+	run(func() { req, reply = parseXREAD(args) })
+	if req == nil {
+		return reply
+	}
+
+	var timeout <-chan time.Time
+	if req.blocking && req.timeout > 0 {
+		timer := time.NewTimer(req.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		var waitChan chan struct{}
+		run(func() { reply, waitChan = req.tryRead() })
+		if reply != "" {
+			return reply
+		}
+		if waitChan == nil {
+			return "$-1\r\n"
+		}
+		select {
+		case <-waitChan:
+			// 有数据更新，重新读取
+		case <-timeout:
+			// 超时返回 NULL
+			unregisterWaiter(req.keys, waitChan)
+			return "$-1\r\n"
+		}
+	}
+}
+
+// 解析 XREAD [BLOCK milliseconds] STREAMS key [key ...] id [id ...]，参数错误时返回错误回复
+func parseXREAD(args []string) (*xreadRequest, string) {
+	if len(args) < 3 {
+		return nil, "-ERR syntax error\r\n"
+	}
+	req := &xreadRequest{ids: make(map[string]string)}
+
+	// 解析 BLOCK 参数
+	if strings.ToLower(args[0]) == "block" {
+		if len(args) < 5 {
+			return nil, "-ERR syntax error\r\n"
+		}
+		blockTime, err := strconv.Atoi(args[1])
+		if err != nil || blockTime < 0 {
+			return nil, "-ERR invalid block time\r\n"
+		}
+		req.blocking = true
+		req.timeout = time.Duration(blockTime) * time.Millisecond
+		args = args[2:] // 移除 BLOCK 参数
+	}
+
+	// 确保 `streams` 关键字正确
+	if strings.ToLower(args[0]) != "streams" || len(args) < 3 || len(args)%2 != 1 {
+		return nil, "-ERR syntax error\r\n"
+	}
+
+	// 解析流及其起始 ID，$ 表示当前流的最新 ID
+	n := (len(args) - 1) / 2
+	unlock := rlockShards(args[1 : n+1])
+	defer unlock()
+	for i := 1; i <= n; i++ {
+		streamKey, lastReadID := args[i], args[i+n]
+		if lastReadID == "$" {
+			if entries := shardFor(streamKey).streams[streamKey]; len(entries) > 0 {
+				lastReadID = entries[len(entries)-1].ID
+			} else {
+				lastReadID = "0-0" // 若流为空，则等待新条目
+			}
+		}
+		if !strings.Contains(lastReadID, "-") {
+			lastReadID += "-0" // 确保 ID 格式正确
+		}
+		req.keys = append(req.keys, streamKey)
+		req.ids[streamKey] = lastReadID
+	}
+	return req, ""
+}
+
+// 读取比请求中 ID 更新的条目；没有新条目且需要阻塞时，在同样的分片读锁内登记等待的 channel
+func (req *xreadRequest) tryRead() (string, chan struct{}) {
+	unlock := rlockShards(req.keys)
+	defer unlock()
+
+	result := make([]string, 0)
+	for _, streamKey := range req.keys {
+		lastTS, lastSeq := parseStreamID(req.ids[streamKey])
+		entries, exists := shardFor(streamKey).streams[streamKey]
+		if !exists {
+			continue
+		}
+
+		streamResult := []string{fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(streamKey), streamKey)}
+		entryCount := 0
+		entryData := make([]string, 0)
+		for _, entry := range entries {
+			ts, seq := parseStreamID(entry.ID)
+			if ts > lastTS || (ts == lastTS && seq > lastSeq) {
+				entryCount++
+				entryPart := []string{fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(entry.ID), entry.ID)}
+				fieldCount := len(entry.Fields) * 2
+				entryPart = append(entryPart, fmt.Sprintf("*%d\r\n", fieldCount))
+				for k, v := range entry.Fields {
+					entryPart = append(entryPart, fmt.Sprintf("$%d\r\n%s\r\n", len(k), k))
+					entryPart = append(entryPart, fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+				}
+				entryData = append(entryData, strings.Join(entryPart, ""))
+			}
+		}
+		if entryCount > 0 {
+			streamResult = append(streamResult, fmt.Sprintf("*%d\r\n%s", entryCount, strings.Join(entryData, "")))
+			result = append(result, strings.Join(streamResult, ""))
+		}
+	}
+	if len(result) > 0 {
+		return fmt.Sprintf("*%d\r\n%s", len(result), strings.Join(result, "")), nil
+	}
+	if !req.blocking {
+		return "", nil
+	}
+
+	// 使用 channel 等待新数据
+	waitChan := make(chan struct{})
+	waitingClients.Lock()
+	for _, streamKey := range req.keys {
+		waitingClients.byKey[streamKey] = append(waitingClients.byKey[streamKey], waitChan)
+	}
+	waitingClients.Unlock()
+	return "", waitChan
+}
+
+// 超时后取消登记，避免 channel 一直留在 waitingClients 中
+func unregisterWaiter(keys []string, waitChan chan struct{}) {
+	waitingClients.Lock()
+	defer waitingClients.Unlock()
+	for _, streamKey := range keys {
+		waiters := waitingClients.byKey[streamKey]
+		for i, ch := range waiters {
+			if ch == waitChan {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(waitingClients.byKey, streamKey)
+		} else {
+			waitingClients.byKey[streamKey] = waiters
+		}
+	}
 }
 
 // XADD 时通知等待的 XREAD,以支持XREADBLOCK 0参数取消阻塞
+// 一个 channel 可能登记在多个流上，关闭后从所有流上移除，避免重复关闭
 func notifyClients(streamKey string) {
-    store.Lock()
-    if clients, ok := waitingClients[streamKey]; ok {
-        for _, ch := range clients {
-            close(ch) // 通知所有等待的 XREAD
-        }
-        delete(waitingClients, streamKey) // 清除已通知的 channel
-    }
-    store.Unlock()
+	waitingClients.Lock()
+	defer waitingClients.Unlock()
+	notified := waitingClients.byKey[streamKey]
+	delete(waitingClients.byKey, streamKey) // 清除已通知的 channel
+	for _, ch := range notified {
+		close(ch) // 通知所有等待的 XREAD
+		for key, waiters := range waitingClients.byKey {
+			for i, other := range waiters {
+				if other == ch {
+					waiters = append(waiters[:i], waiters[i+1:]...)
+					break
+				}
+			}
+			if len(waiters) == 0 {
+				delete(waitingClients.byKey, key)
+			} else {
+				waitingClients.byKey[key] = waiters
+			}
+		}
+	}
 }
 
 
@@ -682,12 +892,15 @@ func handleINCR(args []string) string {
 
     key := args[0]
 
-    store.Lock()
-    defer store.Unlock()
+    defer trackingInvalidateKey(key) // 解锁后再通知缓存了该 key 的客户端
+    shard := shardFor(key)
+    shard.Lock()
+    defer shard.Unlock()
 
-    value, exists := store.data[key]
+    value, exists := shard.data[key]
     if !exists {
-        store.data[key] = "1"
+        shard.data[key] = "1"
+        markDirty(1)
         return ":1\r\n"  // Redis 整数响应格式
     }
 
@@ -697,7 +910,8 @@ func handleINCR(args []string) string {
     }
 
     num++
-    store.data[key] = strconv.Itoa(num)
+    shard.data[key] = strconv.Itoa(num)
+    markDirty(1)
 
     return fmt.Sprintf(":%d\r\n", num)  // Redis 正确的整数返回格式
 }
@@ -0,0 +1,65 @@
+package main
+
+import "strings"
+
+// 命令标志，和 Redis COMMAND INFO 中的 flags 对应
+type commandFlags uint
+
+const (
+	cmdWrite    commandFlags = 1 << iota // 修改数据：执行成功后传播，只读 slave 拒绝执行
+	cmdReadonly                          // 只读取数据
+	cmdAdmin                             // 管理命令
+	cmdNoscript                          // 不能在脚本中执行
+	cmdNoMulti                           // 不能在 MULTI 中排队（会等待 writeGate 写锁或者长时间阻塞）
+)
+
+// 命令表：所有命令的标志，包括 commandHandlers、clientCommandHandlers 和 processCommand 中直接处理的命令
+var commandTable = map[string]commandFlags{
+	"PING":         0,
+	"ECHO":         0,
+	"QUIT":         0,
+	"AUTH":         cmdNoscript,
+	"HELLO":        cmdNoscript,
+	"CLIENT":       cmdNoscript,
+	"SET":          cmdWrite,
+	"GET":          cmdReadonly,
+	"DEL":          cmdWrite,
+	"INCR":         cmdWrite,
+	"MSET":         cmdWrite,
+	"TYPE":         cmdReadonly,
+	"KEYS":         cmdReadonly,
+	"RENAME":       cmdWrite,
+	"PEXPIREAT":    cmdWrite,
+	"XADD":         cmdWrite,
+	"XRANGE":       cmdReadonly,
+	"XREAD":        cmdReadonly,
+	"PUBLISH":      0,
+	"SUBSCRIBE":    cmdNoscript,
+	"UNSUBSCRIBE":  cmdNoscript,
+	"MULTI":        cmdNoscript,
+	"EXEC":         cmdNoscript,
+	"CONFIG":       cmdAdmin | cmdNoscript,
+	"INFO":         0,
+	"SAVE":         cmdAdmin | cmdNoscript | cmdNoMulti,
+	"BGSAVE":       cmdAdmin | cmdNoscript,
+	"LASTSAVE":     0,
+	"BGREWRITEAOF": cmdAdmin | cmdNoscript | cmdNoMulti,
+	"DEBUG":        cmdAdmin | cmdNoscript | cmdNoMulti,
+	"REPLCONF":     cmdAdmin | cmdNoscript,
+	"PSYNC":        cmdAdmin | cmdNoscript | cmdNoMulti,
+	"REPLICAOF":    cmdAdmin | cmdNoscript | cmdNoMulti,
+	"SLAVEOF":      cmdAdmin | cmdNoscript | cmdNoMulti,
+	"WAIT":         cmdNoscript | cmdNoMulti,
+	"WAITAOF":      cmdNoscript | cmdNoMulti,
+	"ACL":          cmdAdmin | cmdNoscript,
+}
+
+// 命令是否有某个标志，不认识的命令没有任何标志
+func commandHasFlag(cmd string, flag commandFlags) bool {
+	return commandTable[strings.ToUpper(cmd)]&flag != 0
+}
+
+// 判断是否是会修改数据的写命令（需要传播、写入 AOF）
+func isWriteCommand(cmd string) bool {
+	return commandHasFlag(cmd, cmdWrite)
+}
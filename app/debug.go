@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DEBUG IMPORT 执行命令时要查询 commandHandlers，所以在 init 中注册
+func init() {
+	commandHandlers["DEBUG"] = handleDEBUG
+}
+
+// 处理 DEBUG 命令
+//
+//	DEBUG RELOAD         保存 RDB，清空数据后重新载入（检验 RDB 读写是否一致）
+//	DEBUG IMPORT <file>  执行文件中的 RESP 写命令（redis-cli --pipe 的输入格式），返回成功和失败的命令数
+func handleDEBUG(args []string) string {
+	if len(args) == 0 {
+		return "-ERR wrong number of arguments for 'debug' command\r\n"
+	}
+	switch strings.ToUpper(args[0]) {
+	case "RELOAD":
+		if len(args) != 1 {
+			return "-ERR syntax error\r\n"
+		}
+		if err := debugReload(); err != nil {
+			return "-ERR Error trying to load the RDB dump: " + err.Error() + "\r\n"
+		}
+		return "+OK\r\n"
+	case "IMPORT":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'debug|import' command\r\n"
+		}
+		succeeded, failed, err := importCommandFile(args[1])
+		if err != nil {
+			return "-ERR " + err.Error() + "\r\n"
+		}
+		return fmt.Sprintf("*4\r\n$9\r\nsucceeded\r\n:%d\r\n$6\r\nfailed\r\n:%d\r\n", succeeded, failed)
+	}
+	return "-ERR unknown subcommand '" + args[0] + "'. Try DEBUG RELOAD or DEBUG IMPORT <file>.\r\n"
+}
+
+// 保存 RDB 后清空数据并重新载入，期间独占所有分片的事务锁并持有 writeGate 写锁，其他客户端的命令等待
+func debugReload() error {
+	unlockGates := lockAllShardGates()
+	defer unlockGates()
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	rdbConfig.RLock()
+	dir, dbfilename := rdbConfig.dir, rdbConfig.dbfilename
+	rdbConfig.RUnlock()
+	if err := SaveRDB(dir, dbfilename); err != nil {
+		return err
+	}
+	storeFlush()
+	return LoadRDB(dir, dbfilename)
+}
+
+// 执行 RESP 命令文件，返回成功和失败的命令数
+// 先完整检查一遍文件格式，格式错误时不执行任何命令；执行失败的命令（不支持的命令、返回错误）跳过，不回滚已经执行的命令。
+// 执行期间独占所有分片的事务锁并持有 writeGate 写锁，其他客户端的命令等到导入结束，不会看到一半的结果；
+// 成功的命令作为一个 MULTI/EXEC 整体写入 AOF 并发给 slave
+func importCommandFile(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	if _, err := forEachFileCommand(file, func([]string) {}); err != nil {
+		return 0, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	unlockGates := lockAllShardGates()
+	defer unlockGates()
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	succeeded, failed := 0, 0
+	var writeCommands [][]string
+	_, err = forEachFileCommand(file, func(argv []string) {
+		cmd := strings.ToUpper(argv[0])
+		if cmd == "MULTI" || cmd == "EXEC" {
+			return // 导入本身就是一个整体
+		}
+		handler, exists := commandHandlers[cmd]
+		if !exists || !isWriteCommand(cmd) {
+			failed++
+			return
+		}
+		writeCommands = append(writeCommands, expireKeys(commandKeys(cmd, argv[1:]))...)
+		response := handler(argv[1:])
+		if strings.HasPrefix(response, "-") {
+			failed++
+			return
+		}
+		succeeded++
+		writeCommands = append(writeCommands, deterministicCommand(cmd, argv[1:], response))
+	})
+	if err != nil {
+		// 第一遍检查之后文件被修改
+		return succeeded, failed, err
+	}
+	propagateWrites(writeCommands)
+	return succeeded, failed, nil
+}
+
+// 依次读取文件中的 RESP 命令，返回命令数
+func forEachFileCommand(r io.Reader, fn func(argv []string)) (int, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var offset int64
+	count := 0
+	for {
+		argv, n, err := readRESPCommand(reader)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return count, fmt.Errorf("unexpected end of file at offset %d", offset)
+		}
+		if err != nil {
+			return count, fmt.Errorf("bad file format at offset %d: %v", offset, err)
+		}
+		fn(argv)
+		offset += int64(n)
+		count++
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 无盘同步：master 不生成 RDB 文件，直接把 RDB 写到 slave 的连接上。
+// 发送前不知道长度，使用 EOF 标记格式：$EOF:<40 个随机字符>\r\n<RDB 数据><同样的 40 个字符>
+
+const rdbEOFMarkLen = 40
+
+var errNoReplicasLeft = errors.New("all replicas disconnected")
+
+// slave 是否使用无盘同步：开启了 repl-diskless-sync，并且 slave 通过 REPLCONF capa eof 声明能解析 EOF 标记格式
+func useDisklessSync(conn *Client) bool {
+	if config.ReplDisklessSync != "yes" {
+		return false
+	}
+	config.Lock()
+	defer config.Unlock()
+	for _, capa := range conn.repl.capa {
+		if capa == "eof" {
+			return true
+		}
+	}
+	return false
+}
+
+// slave 等待下一次无盘同步，第一个 slave 到来时开始计时，repl-diskless-sync-delay 秒内到来的 slave 共用一次传输
+func queueDisklessSync(conn *Client) {
+	config.Lock()
+	defer config.Unlock()
+	config.disklessPending = append(config.disklessPending, conn)
+	if len(config.disklessPending) == 1 {
+		time.AfterFunc(time.Duration(config.ReplDisklessSyncDelay)*time.Second, startDisklessSync)
+	}
+	fmt.Printf("Replica %s asks for diskless full resync, starting in %d seconds\n",
+		conn.RemoteAddr(), config.ReplDisklessSyncDelay)
+}
+
+// 等待期间断开的 slave 不再参与同步；调用时持有 config 锁
+func removeDisklessPending(conn *Client) {
+	for i, pending := range config.disklessPending {
+		if pending == conn {
+			config.disklessPending = append(config.disklessPending[:i], config.disklessPending[i+1:]...)
+			return
+		}
+	}
+}
+
+// 开始无盘同步：和 fullResync 一样在 writeGate 写锁内生成快照并登记所有等待中的 slave，然后把同一份 RDB 发给它们
+func startDisklessSync() {
+	var targets []*Client
+	var snapshot *storeSnapshot
+	runOnExecutor(func() {
+		replApplyLock.Lock()
+		defer replApplyLock.Unlock()
+		writeGate.Lock()
+		defer writeGate.Unlock()
+		snapshot = snapshotStore()
+		config.Lock()
+		defer config.Unlock()
+		reply := []byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n", config.MasterReplID, config.ReplOffset))
+		for _, conn := range config.disklessPending {
+			if conn.queueReply(reply) != nil {
+				continue
+			}
+			conn.holdOutput()
+			addReplica(conn, "send_bulk")
+			targets = append(targets, conn)
+		}
+		config.disklessPending = nil
+	})
+	if len(targets) == 0 {
+		return
+	}
+	for _, conn := range targets {
+		conn.flush()
+	}
+	fmt.Printf("Starting diskless full resync with %d replicas\n", len(targets))
+	sendSnapshotDiskless(targets, snapshot)
+}
+
+// 把快照编码成 RDB 直接写到所有 slave 的连接，不保存 RDB 文件
+func sendSnapshotDiskless(targets []*Client, snapshot *storeSnapshot) {
+	start := time.Now()
+	mark := newReplicationID() // 40 个随机的十六进制字符，和复制 ID 的生成方式相同
+	out := &replicaFanout{replicas: targets}
+	out.Write([]byte("$EOF:" + mark + "\r\n"))
+	w := bufio.NewWriterSize(out, 64*1024)
+	err := writeRDB(w, snapshot, false)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Println("Diskless full resync failed:", err)
+		for _, conn := range out.replicas {
+			conn.Conn.Close()
+		}
+		return
+	}
+	for _, conn := range out.replicas {
+		conn.releaseOutput([]byte(mark))
+		setReplicaState(conn, "online")
+		fmt.Printf("Diskless synchronization with replica %s succeeded (%.3f seconds)\n",
+			conn.RemoteAddr(), time.Since(start).Seconds())
+	}
+}
+
+// 把 RDB 数据同时写给多个 slave，写失败（连接已断开）的 slave 不再发送，全部断开时返回错误
+type replicaFanout struct {
+	replicas []*Client
+}
+
+func (f *replicaFanout) Write(p []byte) (int, error) {
+	alive := f.replicas[:0]
+	for _, conn := range f.replicas {
+		if err := conn.writePayload(p); err != nil {
+			fmt.Printf("Diskless full resync with replica %s failed: %v\n", conn.RemoteAddr(), err)
+			continue
+		}
+		alive = append(alive, conn)
+	}
+	f.replicas = alive
+	if len(alive) == 0 {
+		return 0, errNoReplicasLeft
+	}
+	return len(p), nil
+}
+
+// slave 接收并载入全量同步的 RDB，header 是 $<length> 或者无盘同步的 $EOF:<mark>
+// repl-diskless-load disabled 时先完整接收再载入；on-empty-db 且没有数据时边接收边载入；
+// swapdb 时边接收边解析到内存，传输成功后再替换现有数据，中途断开时保留原来的数据
+func receiveMasterRDB(link *masterLink, reader *bufio.Reader, header string) error {
+	length, mark := int64(-1), []byte(nil)
+	switch {
+	case strings.HasPrefix(header, "$EOF:"):
+		mark = []byte(header[len("$EOF:"):])
+		if len(mark) != rdbEOFMarkLen {
+			return fmt.Errorf("invalid RDB EOF mark: %q", header)
+		}
+	case strings.HasPrefix(header, "$"):
+		n, err := strconv.ParseInt(header[1:], 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid RDB length: %q", header)
+		}
+		length = n
+	default:
+		return fmt.Errorf("invalid RDB length line: %q", header)
+	}
+
+	mode := config.ReplDisklessLoad
+	if mode == "on-empty-db" && len(storeKeys("*")) > 0 {
+		mode = "disabled"
+	}
+	switch mode {
+	case "on-empty-db":
+		fmt.Println("MASTER <-> REPLICA sync: loading DB in memory directly from socket")
+		return loadMasterRDB(link, func() (rdbLoadStats, error) {
+			return loadRDBStream(reader, length, mark, storeLoadValue)
+		})
+	case "swapdb":
+		fmt.Println("MASTER <-> REPLICA sync: parsing DB from socket, keeping the current dataset until it succeeds")
+		type loadedKey struct {
+			key      string
+			value    interface{}
+			expireAt int64
+		}
+		var keys []loadedKey
+		stats, err := loadRDBStream(reader, length, mark, func(key string, value interface{}, expireAt int64) error {
+			keys = append(keys, loadedKey{key, value, expireAt})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return loadMasterRDB(link, func() (rdbLoadStats, error) {
+			for _, k := range keys {
+				if err := storeLoadValue(k.key, k.value, k.expireAt); err != nil {
+					return stats, err
+				}
+			}
+			return stats, nil
+		})
+	}
+
+	data, err := readRDBPayload(reader, length, mark)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("MASTER <-> REPLICA sync: receiving %d bytes from master\n", len(data))
+	return loadMasterRDB(link, func() (rdbLoadStats, error) {
+		return loadRDB(bytes.NewReader(data))
+	})
+}
+
+// 边接收边解析 RDB：length 为 -1 时 RDB 之后是 EOF 标记，否则正好读取 length 字节
+func loadRDBStream(reader *bufio.Reader, length int64, mark []byte, load func(string, interface{}, int64) error) (rdbLoadStats, error) {
+	if mark == nil {
+		limited := &io.LimitedReader{R: reader, N: length}
+		stats, err := loadRDBWith(limited, load)
+		if err != nil {
+			return stats, err
+		}
+		_, err = io.Copy(io.Discard, limited) // RDB 之后多余的字节
+		return stats, err
+	}
+	// rdb.Reader 直接使用带缓冲的 reader，不会读走 RDB 之后的标记
+	stats, err := loadRDBWith(reader, load)
+	if err != nil {
+		return stats, err
+	}
+	tail := make([]byte, len(mark))
+	if _, err := io.ReadFull(reader, tail); err != nil {
+		return stats, fmt.Errorf("error reading RDB EOF mark: %v", err)
+	}
+	if !bytes.Equal(tail, mark) {
+		return stats, errors.New("RDB EOF mark mismatch")
+	}
+	return stats, nil
+}
+
+// 完整接收 RDB 数据：length 为 -1 时一直读到 EOF 标记为止，标记之后的复制流留在 reader 中
+func readRDBPayload(reader *bufio.Reader, length int64, mark []byte) ([]byte, error) {
+	if mark == nil {
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, fmt.Errorf("error reading RDB data: %v", err)
+		}
+		return data, nil
+	}
+	var data []byte
+	for {
+		if _, err := reader.Peek(1); err != nil {
+			return nil, fmt.Errorf("error reading RDB data: %v", err)
+		}
+		chunk, _ := reader.Peek(reader.Buffered())
+		// 标记可能跨越两次读取，从已接收数据的末尾开始查找
+		from := max(len(data)-len(mark)+1, 0)
+		data = append(data, chunk...)
+		if i := bytes.Index(data[from:], mark); i >= 0 {
+			end := from + i + len(mark)
+			reader.Discard(len(chunk) - (len(data) - end))
+			return data[:end-len(mark)], nil
+		}
+		reader.Discard(len(chunk))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "sync"
+
+// 单线程执行模式（-single-threaded yes）：网络协程只负责读取和解析命令，
+// 所有访问 keyspace 的操作都交给一个执行协程按顺序运行，类似 Redis 的主线程。
+// 每条命令（包括 EXEC 的整个事务）天然是原子的，写入 AOF 和传播给副本的顺序就是执行顺序，
+// store 不再需要加锁
+var executor = struct {
+	enabled bool // 启动时确定，之后只读
+	mode    string
+	tasks   chan func()
+}{
+	mode:  "no",
+	tasks: make(chan func(), 1024),
+}
+
+// 启动执行协程，只在单线程模式下调用一次
+func startExecutor() {
+	go func() {
+		for task := range executor.tasks {
+			task()
+		}
+	}()
+}
+
+// 在执行协程中运行 fn 并等待完成；没有开启单线程模式时直接在当前协程运行
+// 不能在执行协程内部调用（fn 中也不能再调用），否则会死锁
+func runOnExecutor(fn func()) {
+	if !executor.enabled {
+		fn()
+		return
+	}
+	done := make(chan struct{})
+	executor.tasks <- func() {
+		defer close(done)
+		fn()
+	}
+	<-done
+}
+
+// store 使用的读写锁：单线程模式下只有执行协程访问 keyspace，加锁是空操作
+type keyspaceMutex struct {
+	mu sync.RWMutex
+}
+
+func (m *keyspaceMutex) Lock() {
+	if !executor.enabled {
+		m.mu.Lock()
+	}
+}
+
+func (m *keyspaceMutex) Unlock() {
+	if !executor.enabled {
+		m.mu.Unlock()
+	}
+}
+
+func (m *keyspaceMutex) RLock() {
+	if !executor.enabled {
+		m.mu.RLock()
+	}
+}
+
+func (m *keyspaceMutex) RUnlock() {
+	if !executor.enabled {
+		m.mu.RUnlock()
+	}
+}
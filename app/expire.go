@@ -0,0 +1,59 @@
+package main
+
+// 过期 key 的删除：命令访问到过期的 key 时删除（惰性删除），serverCron 定期抽查（主动删除）。
+// 只有 master 删除过期的 key，删除作为 DEL 写入 AOF 并传播给 slave；
+// slave 读取时把过期的 key 当作不存在，不主动删除，等待 master 传播的 DEL，数据和 master 保持一致
+
+// 主动删除每次从每个分片抽查的 key 数（和 Redis 的 ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP 相同）
+const activeExpireSamples = 20
+
+// master 删除 keys 中已经过期的 key，返回对应的 DEL 命令，由调用方和写命令一起按执行顺序传播
+// 调用时独占 keys 所在分片的事务锁，并持有 writeGate 读锁（或写锁）
+func expireKeys(keys []string) [][]string {
+	if getRole() != "master" {
+		return nil
+	}
+	var dels [][]string
+	for _, key := range storeDeleteExpired(keys) {
+		dels = append(dels, []string{"DEL", key})
+	}
+	return dels
+}
+
+// 删除 keys 中已经过期的 key 并立即传播 DEL，每个 DEL 单独传播；调用时的锁和 expireKeys 相同
+func propagateExpired(keys []string) {
+	for _, del := range expireKeys(keys) {
+		propagateWrites([][]string{del})
+	}
+}
+
+// 读命令执行之后删除它读到的过期 key：读命令只持有事务锁的读锁，
+// 先不加锁检查，确实有过期的 key 时再像写命令一样独占分片的事务锁，DEL 和其他写命令按顺序传播
+func expireReadKeys(keys []string) {
+	if getRole() != "master" || !storeHasExpired(keys) {
+		return
+	}
+	unlockGates := lockShardGates(keys)
+	defer unlockGates()
+	writeGate.RLock()
+	defer writeGate.RUnlock()
+	propagateExpired(keys)
+}
+
+// 主动删除：从每个分片的过期时间表中抽查一部分 key，删除已经过期的，由 serverCron 调用
+func activeExpireCycle() {
+	if getRole() != "master" {
+		return
+	}
+	for _, shard := range store.shards {
+		keys := shard.sampleExpired(activeExpireSamples)
+		if len(keys) == 0 {
+			continue
+		}
+		unlockGates := lockShardGates(keys)
+		writeGate.RLock()
+		propagateExpired(keys)
+		writeGate.RUnlock()
+		unlockGates()
+	}
+}
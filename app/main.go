@@ -2,238 +2,458 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag" //解析 --port 参数
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 服务器配置
 type ServerConfig struct {
 	sync.Mutex
-	Port               int
-	ReplicaOf          string     
-	MasterReplID       string     
-	ReplOffset         int64      
-	replicaConnections []net.Conn 
-	transactionQueue []string	  
-	inTransaction bool			  
+	Port                  int
+	UnixSocket            string // Unix socket 路径，空表示不监听
+	UnixSocketPerm        string // Unix socket 文件的权限（八进制）
+	ReplicaOf             string
+	MasterReplID          string    // 复制 ID，slave 使用 master 的复制 ID
+	MasterReplID2         string    // 上一个复制 ID，故障转移后持有旧 ID 的 slave 仍然可以部分同步
+	ReplOffset            int64     // 复制偏移量：master 传播的（slave 处理的）复制流字节数
+	SecondReplOffset      int64     // MasterReplID2 有效的最大偏移量，-1 表示没有
+	ReplBacklogSize       string    // 复制积压缓冲区大小
+	ReplicaReadOnly       string    // yes|no，slave 是否拒绝客户端的写命令
+	ReplDisklessSync      string    // yes|no，全量同步时直接把 RDB 发送到 slave 的连接，不先写文件
+	ReplDisklessSyncDelay int       // 无盘同步开始前等待的秒数，期间发来 PSYNC 的 slave 共用一次传输
+	ReplDisklessLoad      string    // disabled|on-empty-db|swapdb，slave 是否边接收边解析 RDB
+	disklessPending       []*Client // 等待下一次无盘同步的 slave
+	backlog               *replBacklog
+	ackNotify             chan struct{} // slave 确认偏移量或本地 AOF fsync 后关闭并替换，唤醒等待中的 WAIT
+	waitingClients        int           // 阻塞在 WAIT、WAITAOF 中的客户端数
+	replicas              []*Client     // 连接的 slave，断开后移除，见 replication.go
+
+	// 作为 slave 时和 master 的连接状态
+	slave                atomic.Bool // 当前是否是 slave，REPLICAOF 可以在运行时切换
+	masterLink           *masterLink // 和 master 的复制连接，master 为 nil
+	masterLinkUp         bool        // 已经完成同步，正在接收 master 的复制流
+	masterSyncInProgress bool        // 正在接收、载入 master 发来的 RDB
+	masterLastIO         time.Time   // 最近一次收到 master 数据的时间
 }
 
 var config ServerConfig
 
-// init 函数用于初始化配置，程序执行前隐式自动调用
-func init() {
+// 解析命令行参数并初始化配置，main 开始时调用（不放在 init 中，测试可以不解析命令行直接使用 store 和命令）
+func parseConfig() {
 	// 解析命令行参数
 	flag.IntVar(&config.Port, "p", 6379, "Port number for the Redis server")
-	flag.StringVar(&config.ReplicaOf, "replicaof", "", "Master host and port for replication")
+	flag.StringVar(&config.ReplicaOf, "replicaof", "", "Master host and port for replication, or the path of the master's Unix socket")
+	flag.StringVar(&config.ReplBacklogSize, "repl-backlog-size", "1mb", "Size of the replication backlog used for partial resynchronization")
+	flag.StringVar(&config.ReplicaReadOnly, "replica-read-only", "yes", "Reject write commands from clients while running as a replica (yes|no)")
+	flag.StringVar(&config.ReplDisklessSync, "repl-diskless-sync", "no", "Stream the RDB of a full resync directly to replica sockets instead of saving it first (yes|no)")
+	flag.IntVar(&config.ReplDisklessSyncDelay, "repl-diskless-sync-delay", 5, "Seconds to wait before a diskless full resync so more replicas can join the same transfer")
+	flag.StringVar(&config.ReplDisklessLoad, "repl-diskless-load", "disabled", "How a replica loads the RDB of a full resync (disabled|on-empty-db|swapdb)")
+	flag.StringVar(&config.UnixSocket, "unixsocket", "", "Also listen on this Unix socket path")
+	flag.StringVar(&config.UnixSocketPerm, "unixsocketperm", "", "Permissions of the Unix socket file in octal, e.g. 700")
+	flag.StringVar(&authConfig.requirepass, "requirepass", "", "Password clients must send with AUTH before running commands (empty disables)")
+	flag.StringVar(&authConfig.masterauth, "masterauth", "", "Password used to authenticate to the master")
+	flag.StringVar(&authConfig.masteruser, "masteruser", "", "Username used to authenticate to the master (empty uses the default user)")
+	flag.IntVar(&tlsConfig.port, "tls-port", 0, "TLS port number (0 disables TLS)")
+	flag.StringVar(&tlsConfig.certFile, "tls-cert-file", "", "Certificate used by the TLS listener and by replicas connecting to a TLS master")
+	flag.StringVar(&tlsConfig.keyFile, "tls-key-file", "", "Private key of tls-cert-file")
+	flag.StringVar(&tlsConfig.caCertFile, "tls-ca-cert-file", "", "CA certificate used to verify peers")
+	flag.StringVar(&tlsConfig.authClients, "tls-auth-clients", tlsConfig.authClients, "Require client certificates on the TLS port (yes|no|optional)")
+	flag.StringVar(&tlsConfig.replication, "tls-replication", tlsConfig.replication, "Connect to the master over TLS (yes|no)")
+	flag.StringVar(&acl.file, "aclfile", "", "File holding ACL users, loaded at startup and by ACL LOAD, written by ACL SAVE")
+	flag.StringVar(&rdbConfig.save, "save", rdbConfig.save, "Automatic RDB save points as \"<seconds> <changes> ...\" (empty disables)")
+	flag.StringVar(&aofConfig.appendonly, "appendonly", aofConfig.appendonly, "Enable append-only file persistence (yes|no)")
+	flag.StringVar(&aofConfig.filename, "appendfilename", aofConfig.filename, "Name of the append-only file")
+	flag.StringVar(&aofConfig.fsync, "appendfsync", aofConfig.fsync, "AOF fsync policy (always|everysec|no)")
+	flag.StringVar(&aofConfig.loadTruncated, "aof-load-truncated", aofConfig.loadTruncated, "Load an AOF whose tail is truncated (yes|no)")
+	flag.StringVar(&aofConfig.dirname, "appenddirname", aofConfig.dirname, "Directory holding the AOF base, incr and manifest files")
+	flag.IntVar(&aofConfig.rewritePercentage, "auto-aof-rewrite-percentage", aofConfig.rewritePercentage, "Rewrite the AOF when it grows by this percentage (0 disables)")
+	flag.StringVar(&aofConfig.useRDBPreamble, "aof-use-rdb-preamble", aofConfig.useRDBPreamble, "Write the AOF base file in RDB format on rewrite (yes|no)")
+	flag.StringVar(&aofConfig.rewriteMinSizeFlag, "auto-aof-rewrite-min-size", aofConfig.rewriteMinSizeFlag, "Minimum AOF size for automatic rewrite")
+	flag.IntVar(&store.count, "keyspace-shards", store.count, "Number of keyspace shards, each with its own lock")
+	flag.StringVar(&executor.mode, "single-threaded", executor.mode, "Execute all commands on a single goroutine that owns the keyspace (yes|no)")
+	flag.StringVar(&clientConfig.outputBufferLimit, "client-output-buffer-limit", clientConfig.outputBufferLimit, "Output buffer limits as \"<class> <hard> <soft> <soft seconds> ...\" for normal, replica and pubsub clients")
+	flag.StringVar(&clientConfig.protoMaxBulkLen, "proto-max-bulk-len", clientConfig.protoMaxBulkLen, "Maximum size of a single argument in a client request")
 	flag.Parse()
 
-	// 设置复制 ID 和偏移量（主节点）
-	config.MasterReplID = "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-	config.ReplOffset = -120 // 平衡各个slave偏移量为0，因为这里的握手我时按照命令来做的
-}
+	saveParams, err := parseSaveParams(rdbConfig.save)
+	if err != nil {
+		log.Fatalf("Invalid save parameters: %v", err)
+	}
+	rdbConfig.saveParams = saveParams
 
-// 启动 Redis 服务器
-func main() {
-	//init隐式调用
-	// 如果是slave，先与主服务器握手
-	if config.ReplicaOf != "" { //代表是slave
-		masterHost, masterPort := parseReplicaOf(config.ReplicaOf)                 // 解析--replicaof参数，提取master的host和端口
-		conn, replID, emptyRDB, err := handshakeWithMaster(masterHost, masterPort) // 发起连接主服务器并获取最终的ID和RDB文件
+	limits, err := parseOutputBufferLimits(clientConfig.outputBufferLimit)
+	if err != nil {
+		log.Fatalf("Invalid client-output-buffer-limit: %v", err)
+	}
+	clientConfig.limits = limits
 
-		if err != nil {
-			log.Fatalf("Error handshaking with master: %v", err)
+	maxBulkLen, err := parseMemorySize(clientConfig.protoMaxBulkLen)
+	if err != nil || maxBulkLen < 1<<20 {
+		log.Fatalf("Invalid proto-max-bulk-len value: %s", clientConfig.protoMaxBulkLen)
+	}
+	clientConfig.maxBulkLen = maxBulkLen
+
+	if config.UnixSocketPerm != "" {
+		if _, err := strconv.ParseUint(config.UnixSocketPerm, 8, 32); err != nil {
+			log.Fatalf("Invalid unixsocketperm value: %s", config.UnixSocketPerm)
 		}
+	}
 
-		// defer conn.Close()
+	if err := initTLS(); err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
 
-		// 先不对得到的ID和emptyRDB做任何处理，等到后面有空再处理
-		fmt.Printf("Handshaked with master, REPL_ID: %s and empty RDB: %s\n", replID, emptyRDB)
+	if err := initACL(); err != nil {
+		log.Fatalf("Error loading ACL file: %v", err)
+	}
 
-		// 在这里开始处理来自主服务器的命令
-		go handleMasterCommands(conn) // 在另一个 goroutine 中处理来自 master 的命令
+	if store.count < 1 {
+		log.Fatalf("Invalid keyspace-shards value: %d", store.count)
+	}
+	initStore()
+
+	switch executor.mode {
+	case "yes":
+		executor.enabled = true
+		startExecutor()
+	case "no":
+	default:
+		log.Fatalf("Invalid single-threaded value: %s", executor.mode)
+	}
 
-		//*** 让 slave 监听客户端请求 ***
-		address := fmt.Sprintf(":%d", config.Port)
-		ln, err := net.Listen("tcp", address)
-		if err != nil {
-			fmt.Printf("Failed to bind port %d: %v\n", config.Port, err)
-			return
-		}
-		defer ln.Close()
+	// 设置复制 ID 和偏移量（主节点），每次启动生成新的复制 ID，重启前的 slave 不会被误认为可以部分同步
+	backlogSize, err := parseMemorySize(config.ReplBacklogSize)
+	if err != nil || backlogSize < 1 {
+		log.Fatalf("Invalid repl-backlog-size value: %s", config.ReplBacklogSize)
+	}
+	if config.ReplicaReadOnly != "yes" && config.ReplicaReadOnly != "no" {
+		log.Fatalf("Invalid replica-read-only value: %s", config.ReplicaReadOnly)
+	}
+	if config.ReplDisklessSync != "yes" && config.ReplDisklessSync != "no" {
+		log.Fatalf("Invalid repl-diskless-sync value: %s", config.ReplDisklessSync)
+	}
+	if config.ReplDisklessSyncDelay < 0 {
+		log.Fatalf("Invalid repl-diskless-sync-delay value: %d", config.ReplDisklessSyncDelay)
+	}
+	switch config.ReplDisklessLoad {
+	case "disabled", "on-empty-db", "swapdb":
+	default:
+		log.Fatalf("Invalid repl-diskless-load value: %s", config.ReplDisklessLoad)
+	}
+	config.MasterReplID = newReplicationID()
+	config.MasterReplID2 = strings.Repeat("0", 40)
+	config.ReplOffset = 0
+	config.SecondReplOffset = -1
+	config.backlog = newReplBacklog(backlogSize, 0)
+	config.ackNotify = make(chan struct{})
+}
 
-		fmt.Printf("Slave Redis running on port %d...\n", config.Port)
+// 启动 Redis 服务器
+func main() {
+	parseConfig()
+	if config.ReplicaOf != "" { //代表是slave
+		masterHost, masterPort := parseReplicaOf(config.ReplicaOf) // 解析--replicaof参数，提取master的host和端口
 
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				fmt.Println("Connection error:", err)
-				continue
+		// slave 的 AOF 记录从 master 收到的写命令
+		if aofEnabled() {
+			if err := openAppendOnlyFile(); err != nil {
+				log.Fatalf("Error opening AOF file: %v", err)
 			}
-			go handleReadOnlyClient(conn) // 只允许读取命令，不允许写入命令
 		}
 
+		// 在另一个 goroutine 中连接 master、同步数据并处理 master 传播的命令，连接断开后自动重连
+		config.Lock()
+		startReplication(masterHost, masterPort)
+		config.Unlock()
+
 	} else { // 代表是master
-		// 每次重启 Redis的master 服务器时，都需要读取 RDB 文件
-		err := LoadRDB(rdbConfig.dir, rdbConfig.dbfilename)
-		if err != nil {
-			log.Fatalf("Error reading RDB file: %v", err)
+		// 每次重启 Redis的master 服务器时，都需要读取持久化文件：开启 AOF 时重放 AOF，否则读取 RDB 文件
+		if aofEnabled() {
+			err := LoadAOF(rdbConfig.dir, aofConfig.filename)
+			if err != nil {
+				log.Fatalf("Error loading AOF file: %v", err)
+			}
+		} else {
+			err := LoadRDB(rdbConfig.dir, rdbConfig.dbfilename)
+			if err != nil {
+				log.Fatalf("Error reading RDB file: %v", err)
+			}
 		}
-		// 监听端口
-		address := fmt.Sprintf(":%d", config.Port)
-		ln, err := net.Listen("tcp", address)
-		if err != nil {
-			fmt.Printf("Failed to bind port %d: %v\n", config.Port, err)
-			return
+		storeResetDirty(storeDirty()) // 加载产生的修改不计入 save 规则
+		if aofEnabled() {
+			if err := openAppendOnlyFile(); err != nil {
+				log.Fatalf("Error opening AOF file: %v", err)
+			}
 		}
+	}
+	go serverCron()
 
-		defer ln.Close()
+	// 监听端口，master 和 slave 使用同一个连接处理函数，按当前角色决定是否允许写命令（REPLICAOF 可以切换角色）
+	listeners, err := openListeners()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
-		fmt.Printf("Mini Redis running on port %d as %s...\n", config.Port, getRole())
+	fmt.Printf("Mini Redis running on port %d as %s...\n", config.Port, getRole())
 
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				fmt.Println("Connection error:", err)
-				continue
+	serveListeners(listeners, handleClient)
+}
+
+// 打开 TCP 端口（-p 0 表示不监听）、TLS 端口和 Unix socket
+func openListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	if config.Port != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to bind port %d: %v", config.Port, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	if tlsConfig.port != 0 {
+		ln, err := listenTLS()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("Failed to bind TLS port %d: %v", tlsConfig.port, err)
+		}
+		fmt.Printf("Accepting TLS connections on port %d\n", tlsConfig.port)
+		listeners = append(listeners, ln)
+	}
+	if config.UnixSocket != "" {
+		// 上次运行留下的 socket 文件会导致 bind 失败，先删除
+		os.Remove(config.UnixSocket)
+		ln, err := net.Listen("unix", config.UnixSocket)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("Failed to bind unix socket %s: %v", config.UnixSocket, err)
+		}
+		listeners = append(listeners, ln)
+		if config.UnixSocketPerm != "" {
+			perm, _ := strconv.ParseUint(config.UnixSocketPerm, 8, 32)
+			if err := os.Chmod(config.UnixSocket, os.FileMode(perm)); err != nil {
+				closeAll()
+				return nil, fmt.Errorf("Failed to set permissions of unix socket %s: %v", config.UnixSocket, err)
 			}
-			go handleClient(conn)
 		}
+		fmt.Printf("Accepting connections on unix socket %s\n", config.UnixSocket)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("No port to listen on: -p and -tls-port are 0 and -unixsocket is not set")
 	}
+	return listeners, nil
 }
 
-// master处理客户端(包括slave节点)连接
-func handleClient(conn net.Conn) {
-	defer conn.Close()
+// 在所有监听端口上接受连接，每个连接在自己的协程中交给 handler 处理，直到所有监听关闭
+func serveListeners(listeners []net.Listener, handler func(net.Conn)) {
+	var wg sync.WaitGroup
+	for _, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			defer ln.Close()
+			for {
+				conn, err := ln.Accept()
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				if err != nil {
+					fmt.Println("Connection error:", err)
+					continue
+				}
+				go handler(conn)
+			}
+		}(ln)
+	}
+	wg.Wait()
+}
 
-	// 读取客户端命令
-	reader := bufio.NewReader(conn)
+// 处理客户端(包括slave节点)连接
+func handleClient(netConn net.Conn) {
+	defer netConn.Close()
+
+	// 每个连接一个 Client，conn 的写操作经过 Client 加锁，失效通知等可以安全地并发写入
+	conn := newClient(netConn)
+	defer conn.release()
+
+	// 读取客户端命令，缓冲区和 Redis 的 PROTO_IOBUF_LEN 一样大，批量导入时一次读取多条命令
+	reader := bufio.NewReaderSize(conn, 16*1024)
 
 	for {
-		cmd, args, err := parseRESP(reader)
-		if err != nil {
-			fmt.Println("Error parsing command:", err)
-			return
+		// 缓冲区中没有完整的命令时，把这一批命令的回复一次写出，再阻塞读取
+		if !commandBuffered(reader) {
+			conn.flush()
 		}
-		if cmd == "" {
+		cmd, args, err := parseRESP(reader, conn.isAuthenticated())
+		if err == io.EOF {
 			fmt.Println("Client disconnected.")
 			return
 		}
-
-		if cmd == "PSYNC" {
-			//网络存入master的config.replicaConnections,要保证原子性，多个slave节点下,调用方法
-			config.AddReplicaConnection(conn)
+		if err != nil {
+			fmt.Println("Error parsing command:", err)
+			conn.queueReply([]byte("-ERR Protocol error: " + err.Error() + "\r\n"))
+			return
 		}
 
-		fmt.Println("Received command:", cmd, args)
+		fmt.Println("Received command:", cmd, redactArgs(cmd, args))
 
-		// 处理 MULTI 命令
-		if cmd == "MULTI" {
-			fmt.Println("Received MULTI command, entering transaction mode")
-			config.StartTransaction(conn)
-			continue
-		}
-
-		// 处理 EXEC 命令
-		if cmd == "EXEC" {
-			config.ExecuteTransaction(conn)
-			continue
+		// QUIT 回复 OK 后关闭连接，不需要认证
+		if cmd == "QUIT" {
+			conn.queueReply([]byte("+OK\r\n"))
+			return
 		}
 
-		// 在事务模式下，将命令排队
-		if config.inTransaction {
-			config.QueueTransactionCommand(cmd, args, conn)
-			continue
+		// 阻塞命令可能等待很久，先把前面命令的回复发出去
+		if isBlockingCommand(cmd, args) {
+			conn.flush()
+			// 单线程模式下阻塞的 XREAD 在连接自己的协程中等待，不占用执行协程
+			if executor.enabled && conn.isAuthenticated() && !conn.inTransaction && !conn.inPubSubMode() {
+				if reply := checkCommandPermission(conn, cmd, args); reply != "" {
+					conn.queueReply([]byte(reply))
+					continue
+				}
+				trackReadKeys(conn, cmd, args)
+				conn.queueReply([]byte(runBlockingCommand(cmd, args)))
+				continue
+			}
 		}
+		runOnExecutor(func() { processCommand(conn, cmd, args) })
+	}
+}
 
-		// 非事务模式下，直接处理命令方法分发
-		handler, exists := commandHandlers[cmd]
-		if !exists {
-			conn.Write([]byte("-ERR unknown command\r\n"))
-			continue
-		}
-		response := handler(args)
+// 执行一条客户端命令，回复追加到客户端的输出缓冲区
+// 单线程模式下在执行协程中运行
+func processCommand(conn *Client, cmd string, args []string) {
+	// 设置了 requirepass 时，认证之前只能执行 AUTH 和 HELLO
+	if !conn.isAuthenticated() && !allowedBeforeAuth(cmd) {
+		conn.queueReply([]byte("-NOAUTH Authentication required.\r\n"))
+		return
+	}
 
-		// 当 为REPLCONF ACK *时，是例外，需要返回响应给 主服务器
-		if cmd == "REPLCONF" && args[0] == "GETACK" && args[1] == "*" {
-			fmt.Println("Received", response, "from slave of ", conn.RemoteAddr().String())
+	// ACL：用户要有执行命令、访问命令中的 key 和频道的权限
+	if !allowedBeforeAuth(cmd) {
+		if reply := checkCommandPermission(conn, cmd, args); reply != "" {
+			conn.queueReply([]byte(reply))
+			return
 		}
-		fmt.Println("Executing :", cmd, args, "Response:", response)
-		conn.Write([]byte(response))
 	}
-}
 
-// slave处理来自客户端的读命令
-func handleReadOnlyClient(conn net.Conn) {
-	defer conn.Close()
+	// 只读 slave 不执行客户端的写命令，写命令只能来自 master 的复制流（见 handleMasterCommands）
+	if isWriteCommand(cmd) && getRole() == "slave" && replicaReadOnly() {
+		conn.queueReply([]byte("-READONLY You can't write against a read only replica.\r\n"))
+		return
+	}
 
-	// 读取客户端命令
-	reader := bufio.NewReader(conn)
+	if cmd == "PSYNC" {
+		conn.Lock()
+		conn.isReplica = true
+		conn.Unlock()
+		handlePSYNC(conn, args)
+		return
+	}
 
-	for {
-		cmd, args, err := parseRESP(reader)
-		if err != nil {
-			fmt.Println("Error parsing command:", err)
-			return
-		}
-		if cmd == "" {
-			fmt.Println("Client disconnected.")
+	// slave 发送的 REPLCONF：记录监听端口、能力和确认的偏移量；ACK 不回复（回复会混进发给 slave 的复制流）
+	if cmd == "REPLCONF" {
+		if reply, handled := replconfFromReplica(conn, args); handled {
+			if reply != "" {
+				conn.queueReply([]byte(reply))
+			}
 			return
 		}
+	}
 
-		// 检查是否是只读命令
-		if !isReadCommand(cmd) {
-			conn.Write([]byte("-ERR unknown command or not allowed in read-only mode\r\n"))
-			continue
-		}
+	// 处理 MULTI 命令
+	if cmd == "MULTI" {
+		fmt.Println("Received MULTI command, entering transaction mode")
+		conn.StartTransaction()
+		return
+	}
 
-		// 处理 MULTI 命令
-		if cmd == "MULTI" {
-			fmt.Println("Received MULTI command, entering transaction mode")
-			config.StartTransaction(conn)
-			continue
-		}
+	// 处理 EXEC 命令
+	if cmd == "EXEC" {
+		conn.ExecuteTransaction()
+		return
+	}
 
-		// 处理 EXEC 命令
-		if cmd == "EXEC" {
-			config.ExecuteTransaction(conn)
-			continue
+	// 在事务模式下，将命令排队；需要独占 writeGate 或者会长时间阻塞的命令不能在事务中执行
+	if conn.inTransaction {
+		if commandHasFlag(cmd, cmdNoMulti) {
+			conn.queueReply([]byte("-ERR Command not allowed inside a transaction\r\n"))
+			return
 		}
+		conn.QueueTransactionCommand(cmd, args)
+		return
+	}
 
-		// 在事务模式下，将命令排队
-		if config.inTransaction {
-			config.QueueTransactionCommand(cmd, args, conn)
-			continue
-		}
+	// RESP2 订阅模式下只能执行订阅相关命令
+	if conn.inPubSubMode() && !isPubSubCommand(cmd) {
+		conn.queueReply([]byte("-ERR Can't execute '" + strings.ToLower(cmd) + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"))
+		return
+	}
 
-		// 非事务模式下，直接处理命令方法分发
-		handler, exists := commandHandlers[cmd]
-		if !exists {
-			conn.Write([]byte("-ERR unknown command\r\n"))
-			continue
-		}
+	// 开启 tracking 的客户端，记录本条命令读取的 key
+	trackReadKeys(conn, cmd, args)
+
+	// 需要连接状态的命令
+	if clientHandler, exists := clientCommandHandlers[cmd]; exists {
+		conn.queueReply([]byte(clientHandler(conn, args)))
+		return
+	}
 
-		response := handler(args)
-		conn.Write([]byte(response))
+	// 非事务模式下，直接处理命令方法分发
+	handler, exists := commandHandlers[cmd]
+	if !exists {
+		conn.queueReply([]byte("-ERR unknown command\r\n"))
+		return
 	}
+	response := callCommand(handler, cmd, args)
 
+	fmt.Println("Executing :", cmd, args, "Response:", response)
+	conn.queueReply([]byte(response))
+}
+
+// 后台定时任务，类似 Redis 的 serverCron
+func serverCron() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		runOnExecutor(func() {
+			aofCron()
+			saveCron()
+			replicationCron()
+			activeExpireCycle()
+		})
+	}
 }
 
 // 获取服务器角色
 func getRole() string {
-	if config.ReplicaOf == "" {
+	if !config.slave.Load() {
 		return "master"
 	}
 	return "slave"
 }
 
 // 解析--replicaof参数，提取主机和端口
+// 只有一个以 / 开头的路径时是 master 的 Unix socket，端口为空
 func parseReplicaOf(replicaOf string) (string, string) {
+	if strings.HasPrefix(replicaOf, "/") && !strings.Contains(replicaOf, " ") {
+		return replicaOf, ""
+	}
 	parts := strings.Split(replicaOf, " ")
 	if len(parts) != 2 {
 		fmt.Println("Invalid replicaof format. Expected format: <master_host> <master_port>")
@@ -242,172 +462,180 @@ func parseReplicaOf(replicaOf string) (string, string) {
 	return parts[0], parts[1]
 }
 
-// slave连接master握手过程,返回主服务器的响应中的ID和空的RDB文件
-func handshakeWithMaster(masterHost string, masterPort string) (net.Conn, string, []byte, error) {
-	// 建立与主服务器的连接
-	conn, err := net.Dial("tcp", masterHost+":"+masterPort)
+// slave连接master握手过程：PING、AUTH、REPLCONF，再用 PSYNC 部分或全量同步
+// 返回连接和读取连接的 reader，之后继续从 reader 读取 master 传播的命令；出错时返回错误，由调用方重连
+func handshakeWithMaster(link *masterLink) (net.Conn, *bufio.Reader, error) {
+	// 建立与主服务器的连接，REPLICAOF 停止复制时关闭这个连接，握手随之失败
+	conn, err := dialMaster(link.host, link.port)
 	if err != nil {
-		conn.Close()
-		fmt.Println("Error connecting to master:", err)
-		os.Exit(1)
+		return nil, nil, fmt.Errorf("error connecting to master: %v", err)
 	}
-	// defer conn.Close() // 连接到主服务器后，不需要关闭
-
-	// 构造并发送PING命令
-	pingCmd := "*1\r\n$4\r\nPING\r\n"
-	_, err = conn.Write([]byte(pingCmd))
-	if err != nil {
-		fmt.Println("Error sending PING command:", err)
-		os.Exit(1)
+	if !link.setConn(conn) {
+		conn.Close()
+		return nil, nil, errReplicationStopped
 	}
-
-	// 读取PING响应并检查
 	reader := bufio.NewReader(conn)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("Error reading response:", err)
-		os.Exit(1)
-	}
-	if strings.TrimSpace(response) != "+PONG" {
-		fmt.Println("Unexpected response to PING requset:", response)
-		os.Exit(1)
+	fail := func(err error) (net.Conn, *bufio.Reader, error) {
+		conn.Close()
+		return nil, nil, err
 	}
-	fmt.Println("Received response from master:", response)
 
-	// 发送REPLCONF listening-port <PORT>
-	listeningPortCmd := "*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$4\r\n" + fmt.Sprintf("%d", config.Port) + "\r\n"
-	_, err = conn.Write([]byte(listeningPortCmd))
-	if err != nil {
-		fmt.Println("Error sending REPLCONF listening-port command:", err)
-		os.Exit(1)
+	// 发送一条命令，读取一行响应
+	sendCommand := func(args ...string) (string, error) {
+		if _, err := conn.Write([]byte(encodeArray(args))); err != nil {
+			return "", fmt.Errorf("error sending %s command: %v", args[0], err)
+		}
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading response to %s: %v", args[0], err)
+		}
+		return strings.TrimSpace(response), nil
 	}
 
-	// 读取REPLCONF listening-port 响应并验证
-	response, err = reader.ReadString('\n')
+	// 发送PING命令并检查响应，有密码的 master 在认证前回复 -NOAUTH，接下来发送 AUTH
+	response, err := sendCommand("PING")
 	if err != nil {
-		fmt.Println("Error reading response:", err)
-		os.Exit(1)
+		return fail(err)
 	}
-	if strings.TrimSpace(response) != "+OK" {
-		fmt.Println("Unexpected response to REPLCONF listening-port command:", response)
-		os.Exit(1)
+	if response != "+PONG" && !(strings.HasPrefix(response, "-NOAUTH") && authConfig.masterauth != "") {
+		return fail(fmt.Errorf("unexpected response to PING requset: %s", response))
 	}
-	fmt.Println("Received valid REPLCONF listening-port response from master:", response)
+	fmt.Println("Received response from master:", response)
 
-	// 发送REPLCONF capa psync2
-	capaPsync2Cmd := "*3\r\n$8\r\nREPLCONF\r\n$4\r\ncapa\r\n$6\r\npsync2\r\n"
-	_, err = conn.Write([]byte(capaPsync2Cmd))
-	if err != nil {
-		fmt.Println("Error sending REPLCONF capa psync2 command:", err)
-		os.Exit(1)
+	// master 设置了密码时先认证
+	if authConfig.masterauth != "" {
+		authArgs := []string{"AUTH", authConfig.masterauth}
+		if authConfig.masteruser != "" {
+			authArgs = []string{"AUTH", authConfig.masteruser, authConfig.masterauth}
+		}
+		response, err = sendCommand(authArgs...)
+		if err != nil {
+			return fail(err)
+		}
+		if response != "+OK" {
+			return fail(fmt.Errorf("unable to AUTH to MASTER: %s", response))
+		}
+		fmt.Println("Authenticated to master")
 	}
 
-	// 读取REPLCONF capa psync2 响应并验证
-	response, err = reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("Error reading response:", err)
-		os.Exit(1)
+	// 发送REPLCONF listening-port <PORT>，通过 TLS 复制时告诉 master 自己的 TLS 端口
+	listeningPort := config.Port
+	if tlsConfig.replication == "yes" && tlsConfig.port != 0 {
+		listeningPort = tlsConfig.port
 	}
-	if strings.TrimSpace(response) != "+OK" {
-		fmt.Println("Unexpected response to REPLCONF capa psync2 command:", response)
-		os.Exit(1)
-	}
-	fmt.Println("Received valid REPLCONF capa psync2 response from master:", response)
-
-	// 发送PSYNC ? -1,由于这是副本第一次连接到主服务器，因此复制 ID 将为（问号）?
-	// 这是 replica 第一次连接到 master，因此偏移量将为-1
-	psyncCmd := "*3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n"
-	_, err = conn.Write([]byte(psyncCmd))
+	response, err = sendCommand("REPLCONF", "listening-port", strconv.Itoa(listeningPort))
 	if err != nil {
-		fmt.Println("Error sending PSYNC ? -1 command:", err)
-		os.Exit(1)
+		return fail(err)
 	}
-
-	// 读取PSYNC响应并检查
-	response, err = reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("Error reading response:", err)
-		os.Exit(1)
-	}
-	if !strings.HasPrefix(response, "+FULLRESYNC") {
-		fmt.Println("Unexpected response to PSYNC command:", response)
-		os.Exit(1)
+	if response != "+OK" {
+		return fail(fmt.Errorf("unexpected response to REPLCONF listening-port command: %s", response))
 	}
-	// 解析 FULLRESYNC 响应，获取 REPL_ID
-	// 解析 FULLRESYNC 响应
-	parts := strings.Fields(response)
-
-	// 检查解析结果是否正确
-	if len(parts) < 3 {
-		fmt.Println("Invalid FULLRESYNC response:", response)
-		os.Exit(1) // 需要处理退出或返回的方式
-	}
-
-	// 提取 REPL_ID 和状态 (通常是0)
-	replID := parts[1]
-	offset := parts[2] // 这是 0，因为它应该代表偏移量
-	fmt.Printf("Received FULLRESYNC response from master, REPL_ID: %s, status: %s\n", replID, offset)
 
-	// 读取 RDB 文件长度部分
-	rdbLengthLine, err := reader.ReadString('\n')
+	// 发送REPLCONF capa eof capa psync2，eof 表示能接收无盘同步的 EOF 标记格式
+	response, err = sendCommand("REPLCONF", "capa", "eof", "capa", "psync2")
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("error reading RDB length: %v", err)
+		return fail(err)
 	}
-
-	// 去掉末尾的 \r\n，并解析长度
-	rdbLengthLine = strings.TrimSuffix(rdbLengthLine, "\r\n")
-	rdbLength, err := strconv.Atoi(rdbLengthLine[1:]) // rdbLengthLine 应该是 "$<length>"
-	if err != nil {
-		return nil, "", nil, fmt.Errorf("invalid RDB length: %v", err)
+	if response != "+OK" {
+		return fail(fmt.Errorf("unexpected response to REPLCONF capa command: %s", response))
 	}
 
-	// 读取空 RDB 文件的二进制数据
-	emptyRDB := make([]byte, rdbLength)
-	_, err = reader.Read(emptyRDB)
-	if err != nil {
-		return nil, "", nil, fmt.Errorf("error reading RDB data: %v", err)
+	// 发送 PSYNC，部分同步或者载入 master 的 RDB
+	if err := psyncWithMaster(link, conn, reader); err != nil {
+		return fail(err)
 	}
-	// fmt.Printf("Received empty RDB file of length %d bytes and content: %s\n", rdbLength, emptyRDB)
-	// 返回 REPL_ID 和 RDB 文件内容
-	return conn, replID, emptyRDB, nil
+	return conn, reader, nil
 }
 
-// 让 Master 发送命令给 Slave
+// 让 Master 发送命令给 Slave：追加到复制积压缓冲区、增加复制偏移量，再发给所有 slave，发送失败的 slave 被移除
+// 持有 config 锁，所有 slave 和积压缓冲区收到的复制流顺序相同
 func propagateToSlaves(command string) {
-	for _, slave := range config.replicaConnections {
+	config.Lock()
+	defer config.Unlock()
+	config.ReplOffset += int64(len(command))
+	config.backlog.feed([]byte(command))
+	alive := config.replicas[:0]
+	for _, slave := range config.replicas {
 		_, err := slave.Write([]byte(command))
 		// 打印发送
 		fmt.Printf("Sending command to %s: %s\n", slave.RemoteAddr().String(), command)
 
 		if err != nil {
+			// 连接已经关闭（例如超过输出缓冲区限制），不再发送
 			fmt.Println("Failed to propagate to slave:", err)
+			continue
 		}
+		alive = append(alive, slave)
 	}
+	clear(config.replicas[len(alive):])
+	config.replicas = alive
 }
 
-// Slave 解析 Master 发送的命令
-func handleMasterCommands(conn net.Conn) {
-	// defer wg.Done() // 确保 Goroutine 执行完时通知 WaitGroup
-
-	reader := bufio.NewReader(conn)
+// Slave 解析 Master 发送的命令，执行后计入复制偏移量和自己的积压缓冲区，连接出错时返回
+// MULTI 和 EXEC 之间的命令在收到 EXEC 后作为一个整体执行；整个事务执行后才计入偏移量，
+// 事务中途断线时重连从 MULTI 之前继续，不会只执行事务的一部分
+func handleMasterCommands(link *masterLink, conn net.Conn, reader *bufio.Reader) {
+	var transaction [][]string // MULTI 之后收到的命令，nil 表示不在事务中
+	var transactionRaw strings.Builder
 	for {
-		command, args, err := parseRESP(reader)
+		argv, n, err := readRESPCommand(reader)
 		if err != nil {
 			fmt.Println("Error reading command from master:", err)
 			return
 		}
+		// REPLICAOF 已经停止复制，不再执行之前的 master 发来的命令
+		if link.stopped() {
+			return
+		}
+		// 复制流按原样记入积压缓冲区，master 发送的都是标准编码，重新编码和收到的字节相同
+		raw := encodeArray(argv)
+		if len(raw) != n {
+			fmt.Println("Protocol error from master: non-canonical command encoding")
+			return
+		}
+		command, args := strings.ToUpper(argv[0]), argv[1:]
 		fmt.Println("Received command :", command, args, "from ", conn.RemoteAddr().String())
+		config.Lock()
+		config.masterLastIO = time.Now()
+		config.Unlock()
+
+		switch {
+		case command == "MULTI":
+			transaction = [][]string{}
+			transactionRaw.Reset()
+			transactionRaw.WriteString(raw)
+			continue
+		case command == "EXEC" && transaction != nil:
+			commands := transaction
+			transactionRaw.WriteString(raw)
+			block := transactionRaw.String()
+			runOnExecutor(func() {
+				applyMasterStream(block, func() { execCommands(commands) })
+			})
+			transaction = nil
+			continue
+		case transaction != nil:
+			transaction = append(transaction, append([]string{command}, args...))
+			transactionRaw.WriteString(raw)
+			continue
+		}
+
 		if handler, exists := commandHandlers[command]; exists {
-			response := handler(args)
+			var response string
+			runOnExecutor(func() {
+				applyMasterStream(raw, func() { response = callCommand(handler, command, args) })
+			})
 			// conn.Write([]byte(response)) // slave 一般不需要返回响应给 主服务器
 			// 当 为REPLCONF GETACK *时，是例外，需要返回响应给 主服务器
-			if command == "REPLCONF" && args[0] == "GETACK" && args[1] == "*" {
+			if command == "REPLCONF" && len(args) == 2 && strings.ToUpper(args[0]) == "GETACK" && args[1] == "*" {
 				conn.Write([]byte(response))
 			}
 			fmt.Println("Executing from Master:", command, args, "Response:", response)
 		} else {
 			fmt.Println("Unknown command from master:", command)
+			runOnExecutor(func() {
+				applyMasterStream(raw, func() {}) // 不能执行也要转发给下级 slave，复制偏移量保持一致
+			})
 		}
 	}
-	// fmt.Println("Executing from Master:", command, args, "Response:", response)
 }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/resp"
+)
+
+var errClientClosed = errors.New("client connection closed")
+
+// 一类客户端的输出缓冲区限制：超过硬限制立即断开，持续超过软限制 softSeconds 秒后断开，0 表示不限制
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int
+}
+
+// 客户端输出缓冲区配置（client-output-buffer-limit），格式和 Redis 相同：
+// <class> <hard> <soft> <soft seconds>，class 为 normal、replica（或 slave）、pubsub
+var clientConfig = struct {
+	sync.RWMutex
+	outputBufferLimit string
+	limits            map[string]outputBufferLimit
+	protoMaxBulkLen   string // 请求中单个参数的最大长度（proto-max-bulk-len）
+	maxBulkLen        int64
+}{
+	outputBufferLimit: "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60",
+	protoMaxBulkLen:   "512mb",
+}
+
+// 解析请求时的长度限制：未认证的连接使用更小的限制，认证后（以及 AOF、master 的复制流）由 proto-max-bulk-len 限制
+func requestLimits(authenticated bool) resp.Limits {
+	if !authenticated {
+		return resp.UnauthenticatedLimits
+	}
+	clientConfig.RLock()
+	defer clientConfig.RUnlock()
+	return resp.Limits{MaxMultibulk: resp.MaxMultibulk, MaxBulk: clientConfig.maxBulkLen}
+}
+
+// 解析 client-output-buffer-limit，没有指定的类使用默认值
+func parseOutputBufferLimits(s string) (map[string]outputBufferLimit, error) {
+	limits := map[string]outputBufferLimit{
+		"normal":  {},
+		"replica": {hard: 256 << 20, soft: 64 << 20, softSeconds: 60},
+		"pubsub":  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60},
+	}
+	fields := strings.Fields(s)
+	if len(fields)%4 != 0 {
+		return nil, fmt.Errorf("wrong number of arguments in client-output-buffer-limit '%s'", s)
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		if _, ok := limits[class]; !ok {
+			return nil, fmt.Errorf("invalid client class '%s'", fields[i])
+		}
+		hard, err := parseMemorySize(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+		soft, err := parseMemorySize(fields[i+2])
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := strconv.Atoi(fields[i+3])
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid soft limit seconds '%s'", fields[i+3])
+		}
+		limits[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: seconds}
+	}
+	return limits, nil
+}
+
+// 当前配置的字符串形式（CONFIG GET）
+func outputBufferLimitConfig() string {
+	clientConfig.RLock()
+	defer clientConfig.RUnlock()
+	var parts []string
+	for _, class := range []string{"normal", "replica", "pubsub"} {
+		limit := clientConfig.limits[class]
+		parts = append(parts, fmt.Sprintf("%s %d %d %d", class, limit.hard, limit.soft, limit.softSeconds))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 客户端所属的类：副本连接、订阅了频道的连接，其余为普通连接
+func (c *Client) outputClass() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.isReplica {
+		return "replica"
+	}
+	if len(c.subscriptions) > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// 把回复追加到输出缓冲区，等 flush 时由写协程一次写出
+// 客户端不读取回复导致缓冲区超过限制时断开连接
+func (c *Client) queueReply(p []byte) error {
+	class := c.outputClass()
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.closing {
+		return errClientClosed
+	}
+	if c.holding {
+		c.held = append(c.held, p...)
+	} else {
+		c.output = append(c.output, p...)
+	}
+	c.checkOutputLimitLocked(class)
+	return nil
+}
+
+// 暂停发送之后追加的输出（全量同步期间传播给 slave 的命令），之前追加的照常发送
+func (c *Client) holdOutput() {
+	c.outMu.Lock()
+	c.holding = true
+	c.outMu.Unlock()
+}
+
+// 先发送 payload（RDB 数据），再发送暂停期间积累的输出，恢复正常发送
+func (c *Client) releaseOutput(payload []byte) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.closing {
+		return
+	}
+	c.output = append(c.output, payload...)
+	c.output = append(c.output, c.held...)
+	c.held = nil
+	c.holding = false
+	c.flushPending = true
+	c.outCond.Signal()
+}
+
+// 无盘同步时发送一段 RDB 数据，holdOutput 暂停期间积累的输出仍然保留到 releaseOutput
+// 等写协程取走之前的数据再追加，发送速度受最慢的 slave 限制，不会在内存中积累整个 RDB
+func (c *Client) writePayload(p []byte) error {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	for !c.closing && len(c.output) > 0 {
+		c.drained.Wait()
+	}
+	if c.closing {
+		return errClientClosed
+	}
+	c.output = append(c.output, p...)
+	c.flushPending = true
+	c.outCond.Signal()
+	return nil
+}
+
+// 唤醒写协程发送缓冲区中的数据
+func (c *Client) flush() {
+	c.outMu.Lock()
+	if len(c.output) > 0 {
+		c.flushPending = true
+		c.outCond.Signal()
+	}
+	c.outMu.Unlock()
+}
+
+// 写连接：追加到输出缓冲区并立即发送，多个协程写同一连接时不会交错，也不会被不读取的客户端阻塞
+func (c *Client) Write(p []byte) (int, error) {
+	if err := c.queueReply(p); err != nil {
+		return 0, err
+	}
+	c.flush()
+	return len(p), nil
+}
+
+// 检查输出缓冲区限制，调用时需要持有 outMu
+func (c *Client) checkOutputLimitLocked(class string) {
+	clientConfig.RLock()
+	limit := clientConfig.limits[class]
+	clientConfig.RUnlock()
+
+	pending := int64(len(c.output) + c.sending + len(c.held))
+	exceeded := limit.hard > 0 && pending >= limit.hard
+	if limit.soft > 0 && pending >= limit.soft {
+		if c.softLimitSince.IsZero() {
+			c.softLimitSince = time.Now()
+		} else if time.Since(c.softLimitSince) >= time.Duration(limit.softSeconds)*time.Second {
+			exceeded = true
+		}
+	} else {
+		c.softLimitSince = time.Time{}
+	}
+	if exceeded {
+		fmt.Printf("Client id=%d addr=%s class=%s omem=%d scheduled to be closed ASAP for overcoming of output buffer limits.\n",
+			c.ID, c.RemoteAddr(), class, pending)
+		c.closing = true
+		c.output = nil
+		c.held = nil
+		c.outCond.Signal()
+		c.Conn.Close() // 读写都会失败，处理协程随之退出
+	}
+}
+
+// 写协程：把输出缓冲区的数据写入 socket，写入期间其他协程可以继续追加
+func (c *Client) writeLoop() {
+	defer close(c.writerDone)
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	defer c.drained.Broadcast()
+	for {
+		for !c.flushPending && !c.closing {
+			c.outCond.Wait()
+		}
+		if len(c.output) == 0 {
+			if c.closing {
+				return
+			}
+			c.flushPending = false
+			continue
+		}
+		data := c.output
+		c.output = nil
+		c.flushPending = false
+		c.sending = len(data)
+		c.drained.Broadcast()
+		c.outMu.Unlock()
+		_, err := c.Conn.Write(data)
+		c.outMu.Lock()
+		c.sending = 0
+		if err != nil {
+			c.closing = true
+			c.output = nil
+			return
+		}
+	}
+}
+
+// 停止接受新的输出，等待写协程发送完剩余的回复（最多等待几秒）
+func (c *Client) closeOutput() {
+	c.outMu.Lock()
+	if !c.closing {
+		c.closing = true
+		c.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	}
+	c.outCond.Signal()
+	c.outMu.Unlock()
+	<-c.writerDone
+}
+
+// 是否是会阻塞等待的命令（XREAD BLOCK）
+func isBlockingCommand(cmd string, args []string) bool {
+	if cmd == "WAIT" || cmd == "WAITAOF" {
+		return true
+	}
+	if cmd != "XREAD" {
+		return false
+	}
+	for _, arg := range args {
+		if strings.ToUpper(arg) == "BLOCK" {
+			return true
+		}
+	}
+	return false
+}
+
+// 缓冲区中是否已经有一条完整的命令，没有时处理协程先发送积累的回复再阻塞读取
+func commandBuffered(reader *bufio.Reader) bool {
+	data, _ := reader.Peek(reader.Buffered())
+	if len(data) == 0 {
+		return false
+	}
+	if data[0] != '*' {
+		return bytes.IndexByte(data, '\n') >= 0
+	}
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		return false
+	}
+	// 格式错误或超过长度限制时不再等待后续数据，交给 parseRESP 报错
+	limits := requestLimits(true)
+	count, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || count > limits.MaxMultibulk {
+		return true
+	}
+	pos := end + 2
+	for i := 0; i < count; i++ {
+		if pos >= len(data) {
+			return false
+		}
+		if data[pos] != '$' {
+			return true
+		}
+		end := bytes.Index(data[pos:], []byte("\r\n"))
+		if end < 0 {
+			return false
+		}
+		size, err := strconv.ParseInt(string(data[pos+1:pos+end]), 10, 64)
+		if err != nil || size < 0 || size > limits.MaxBulk {
+			return true
+		}
+		pos += end + 2 + int(size) + 2
+	}
+	return pos <= len(data)
+}
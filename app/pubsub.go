@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 频道 -> 订阅该频道的客户端
+var pubsub = struct {
+	sync.RWMutex
+	channels map[string]map[int64]*Client
+}{channels: make(map[string]map[int64]*Client)}
+
+// 处理 SUBSCRIBE channel [channel ...]
+func handleSUBSCRIBE(client *Client, args []string) string {
+	if len(args) < 1 {
+		return "-ERR wrong number of arguments for 'subscribe' command\r\n"
+	}
+	var replies []string
+	for _, channel := range args {
+		pubsub.Lock()
+		if pubsub.channels[channel] == nil {
+			pubsub.channels[channel] = make(map[int64]*Client)
+		}
+		pubsub.channels[channel][client.ID] = client
+		pubsub.Unlock()
+
+		client.Lock()
+		client.subscriptions[channel] = true
+		count := len(client.subscriptions)
+		client.Unlock()
+		replies = append(replies, encodePubSubReply(client, "subscribe", channel, count))
+	}
+	return strings.Join(replies, "")
+}
+
+// 处理 UNSUBSCRIBE [channel ...]，不带参数时退订全部
+func handleUNSUBSCRIBE(client *Client, args []string) string {
+	channels := args
+	if len(channels) == 0 {
+		client.Lock()
+		for channel := range client.subscriptions {
+			channels = append(channels, channel)
+		}
+		client.Unlock()
+	}
+	if len(channels) == 0 {
+		return encodePubSubReply(client, "unsubscribe", "", 0)
+	}
+	var replies []string
+	for _, channel := range channels {
+		count := unsubscribeChannel(client, channel)
+		replies = append(replies, encodePubSubReply(client, "unsubscribe", channel, count))
+	}
+	return strings.Join(replies, "")
+}
+
+// 处理 PUBLISH channel message，返回收到消息的客户端数
+func handlePUBLISH(args []string) string {
+	if len(args) != 2 {
+		return "-ERR wrong number of arguments for 'publish' command\r\n"
+	}
+	return fmt.Sprintf(":%d\r\n", publishMessage(args[0], encodeBulkString(args[1])))
+}
+
+// 向频道的所有订阅者发送消息，payload 为已编码的 RESP 数据
+func publishMessage(channel, payload string) int {
+	pubsub.RLock()
+	subscribers := make([]*Client, 0, len(pubsub.channels[channel]))
+	for _, client := range pubsub.channels[channel] {
+		subscribers = append(subscribers, client)
+	}
+	pubsub.RUnlock()
+
+	for _, client := range subscribers {
+		client.sendMessage(channel, payload)
+	}
+	return len(subscribers)
+}
+
+// 向单个订阅者发送 message 消息
+func (c *Client) sendMessage(channel, payload string) {
+	header := "*3\r\n"
+	if c.respVersion() == 3 {
+		header = ">3\r\n"
+	}
+	c.Write([]byte(header + encodeBulkString("message") + encodeBulkString(channel) + payload))
+}
+
+// 客户端是否订阅了某个频道
+func (c *Client) isSubscribed(channel string) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.subscriptions[channel]
+}
+
+// 退订一个频道，返回剩余订阅数
+func unsubscribeChannel(client *Client, channel string) int {
+	pubsub.Lock()
+	if subscribers, ok := pubsub.channels[channel]; ok {
+		delete(subscribers, client.ID)
+		if len(subscribers) == 0 {
+			delete(pubsub.channels, channel)
+		}
+	}
+	pubsub.Unlock()
+
+	client.Lock()
+	defer client.Unlock()
+	delete(client.subscriptions, channel)
+	return len(client.subscriptions)
+}
+
+// 连接断开时退订所有频道
+func unsubscribeAll(client *Client) {
+	client.Lock()
+	channels := make([]string, 0, len(client.subscriptions))
+	for channel := range client.subscriptions {
+		channels = append(channels, channel)
+	}
+	client.Unlock()
+	for _, channel := range channels {
+		unsubscribeChannel(client, channel)
+	}
+}
+
+// 构造 subscribe/unsubscribe 回复，RESP3 下为 push 类型
+func encodePubSubReply(client *Client, kind, channel string, count int) string {
+	header := "*3\r\n"
+	if client.respVersion() == 3 {
+		header = ">3\r\n"
+	}
+	name := "$-1\r\n"
+	if channel != "" {
+		name = encodeBulkString(channel)
+	}
+	return header + encodeBulkString(kind) + name + fmt.Sprintf(":%d\r\n", count)
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 复制积压缓冲区：环形缓冲区，保存最近的复制流，slave 断线重连后从中补发缺失的部分
+type replBacklog struct {
+	buf     []byte
+	idx     int   // 下一个写入的位置
+	histlen int   // 有效数据的长度
+	start   int64 // 缓冲区中第一个字节之前的复制偏移量，start+histlen 等于当前复制偏移量
+}
+
+// slave 执行 master 传播的命令时要查询 commandHandlers，所以 REPLICAOF 在 init 中注册
+func init() {
+	commandHandlers["REPLICAOF"] = handleREPLICAOF
+	commandHandlers["SLAVEOF"] = handleREPLICAOF // SLAVEOF 是 REPLICAOF 的旧名字
+}
+
+// slave 连接在 master 上的复制状态
+type replicaState struct {
+	state         string    // wait_bgsave：等待生成快照，send_bulk：发送 RDB，online：接收复制流
+	listeningPort int       // REPLCONF listening-port 告知的端口
+	capa          []string  // REPLCONF capa 告知的能力，如 psync2
+	ackOffset     int64     // slave 确认已经处理的复制偏移量
+	ackAOFOffset  int64     // slave 确认已经 fsync 到 AOF 的复制偏移量，-1 表示没有开启 AOF
+	ackTime       time.Time // 最近一次收到 ACK 的时间
+}
+
+// slave 和 master 的复制连接，REPLICAOF 切换 master 或提升为 master 时停止
+type masterLink struct {
+	host, port string
+	stop       chan struct{} // 停止复制时关闭
+	conn       net.Conn      // 当前和 master 的连接，由 config 锁保护
+}
+
+var errReplicationStopped = errors.New("replication stopped")
+
+const (
+	replReconnectMin = 100 * time.Millisecond // slave 重连 master 的等待时间，每次失败加倍
+	replReconnectMax = 5 * time.Second
+)
+
+// 创建积压缓冲区，offset 是当前的复制偏移量
+func newReplBacklog(size int64, offset int64) *replBacklog {
+	return &replBacklog{buf: make([]byte, size), start: offset}
+}
+
+// 追加复制流，写满后覆盖最旧的数据；调用时持有 config 锁
+func (b *replBacklog) feed(data []byte) {
+	size := len(b.buf)
+	if len(data) >= size {
+		// 只保留最后 size 个字节
+		b.start += int64(b.histlen + len(data) - size)
+		copy(b.buf, data[len(data)-size:])
+		b.idx = 0
+		b.histlen = size
+		return
+	}
+	n := copy(b.buf[b.idx:], data)
+	copy(b.buf, data[n:])
+	b.idx = (b.idx + len(data)) % size
+	b.histlen += len(data)
+	if b.histlen > size {
+		b.start += int64(b.histlen - size)
+		b.histlen = size
+	}
+}
+
+// 复制偏移量 from 之后的所有数据，from 已经不在缓冲区中时返回 false；调用时持有 config 锁
+func (b *replBacklog) since(from int64) ([]byte, bool) {
+	if from < b.start || from > b.start+int64(b.histlen) {
+		return nil, false
+	}
+	skip := int(from - b.start)
+	out := make([]byte, b.histlen-skip)
+	first := (b.idx - b.histlen + skip + 2*len(b.buf)) % len(b.buf)
+	n := copy(out, b.buf[first:])
+	copy(out[n:], b.buf)
+	return out, true
+}
+
+// 生成新的复制 ID：40 个十六进制字符
+func newReplicationID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// 切换到新的复制 ID，旧 ID 作为 replid2 保留：持有旧 ID 且偏移量不超过 second_repl_offset 的 slave 仍然可以部分同步
+// 调用时持有 config 锁
+func shiftReplicationID(newID string) {
+	config.MasterReplID2 = config.MasterReplID
+	config.SecondReplOffset = config.ReplOffset + 1
+	config.MasterReplID = newID
+	fmt.Printf("Setting secondary replication ID to %s, valid up to offset: %d. New replication ID is %s\n",
+		config.MasterReplID2, config.SecondReplOffset, config.MasterReplID)
+}
+
+// 传播执行成功的写命令：追加到 AOF，master 还要发给所有 slave，多条命令（事务）用 MULTI/EXEC 包裹
+// slave 不在这里转发，它从 master 收到的复制流由 handleMasterCommands 原样记录
+// 调用时持有 writeGate 读锁（或写锁）
+func propagateWrites(commands [][]string) {
+	if len(commands) == 0 {
+		return
+	}
+	feedAppendOnlyFileBatch(commands)
+	if getRole() == "master" {
+		propagateToSlaves(encodeCommandBatch(commands))
+	}
+}
+
+// slave 执行 master 发来的命令并转发给自己的 slave 期间持有。全量同步 sub-replica 时先获取这个锁，
+// 快照中的数据和回复的偏移量一致：不会包含已经执行但还没有计入偏移量的命令
+var replApplyLock sync.Mutex
+
+// slave 执行复制流中的一段命令，然后把收到的字节原样转发给自己的 slave 并计入偏移量
+// 单线程模式下在执行协程中调用
+func applyMasterStream(raw string, execute func()) {
+	replApplyLock.Lock()
+	defer replApplyLock.Unlock()
+	execute()
+	propagateToSlaves(raw)
+}
+
+// 断开所有 slave：复制 ID 变化或者数据被替换后，让它们重新 PSYNC 得到新的 ID 或者全量同步；调用时持有 config 锁
+func disconnectReplicas() {
+	for _, replica := range config.replicas {
+		replica.Conn.Close()
+	}
+}
+
+// 登记 slave，之后传播的命令都会发给它；调用时持有 config 锁
+func addReplica(conn *Client, state string) {
+	conn.repl.state = state
+	conn.repl.ackTime = time.Now()
+	config.replicas = append(config.replicas, conn)
+}
+
+// 移除断开的 slave，连接释放时调用
+func removeReplica(conn *Client) {
+	config.Lock()
+	defer config.Unlock()
+	removeDisklessPending(conn)
+	for i, replica := range config.replicas {
+		if replica == conn {
+			config.replicas = append(config.replicas[:i], config.replicas[i+1:]...)
+			fmt.Printf("Connection with replica %s lost.\n", conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// 设置 slave 的同步状态
+func setReplicaState(conn *Client, state string) {
+	config.Lock()
+	conn.repl.state = state
+	config.Unlock()
+}
+
+// master 处理 slave 发送的 REPLCONF listening-port、capa、ACK，返回回复和是否已经处理
+func replconfFromReplica(conn *Client, args []string) (string, bool) {
+	if len(args) < 2 {
+		return "", false
+	}
+	switch strings.ToLower(args[0]) {
+	case "listening-port":
+		port, err := strconv.Atoi(args[1])
+		if err != nil || port < 0 || port > 65535 {
+			return "-ERR invalid listening-port\r\n", true
+		}
+		config.Lock()
+		conn.repl.listeningPort = port
+		config.Unlock()
+		return "+OK\r\n", true
+	case "capa":
+		// REPLCONF capa <capability> [capa <capability> ...]
+		config.Lock()
+		for i := 1; i < len(args); i += 2 {
+			if i == 1 || strings.ToLower(args[i-1]) == "capa" {
+				conn.repl.capa = append(conn.repl.capa, strings.ToLower(args[i]))
+			}
+		}
+		config.Unlock()
+		return "+OK\r\n", true
+	case "ack":
+		handleReplconfAck(conn, args[1:])
+		return "", true
+	}
+	return "", false
+}
+
+// slave 是否拒绝客户端的写命令（replica-read-only）
+func replicaReadOnly() bool {
+	return config.ReplicaReadOnly == "yes"
+}
+
+// 当前的复制偏移量
+func currentReplOffset() int64 {
+	config.Lock()
+	defer config.Unlock()
+	return config.ReplOffset
+}
+
+// 处理 PSYNC <replid> <offset>：复制 ID 匹配且积压缓冲区中还有 offset 之后的数据时部分同步（+CONTINUE），否则全量同步
+func handlePSYNC(conn *Client, args []string) {
+	if len(args) != 2 {
+		conn.queueReply([]byte("-ERR wrong number of arguments for 'psync' command\r\n"))
+		return
+	}
+	if partialResync(conn, args[0], args[1]) {
+		return
+	}
+	if useDisklessSync(conn) {
+		queueDisklessSync(conn)
+		return
+	}
+	fullResync(conn)
+}
+
+// 部分同步：回复 +CONTINUE 并补发积压缓冲区中 slave 缺少的数据
+// 在 config 锁内补发和登记 slave，传播命令也要持有这把锁，补发的数据和之后传播的命令之间不会有遗漏或重复
+func partialResync(conn *Client, replID, offsetArg string) bool {
+	psyncOffset, err := strconv.ParseInt(offsetArg, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	config.Lock()
+	defer config.Unlock()
+	if replID != config.MasterReplID && (replID != config.MasterReplID2 || psyncOffset > config.SecondReplOffset) {
+		if replID != "?" {
+			fmt.Printf("Partial resynchronization not accepted: replication ID mismatch (replica asked for '%s', my replication IDs are '%s' and '%s')\n",
+				replID, config.MasterReplID, config.MasterReplID2)
+		}
+		return false
+	}
+	// slave 发送的是下一个需要的字节的偏移量
+	missing, ok := config.backlog.since(psyncOffset - 1)
+	if !ok {
+		fmt.Printf("Unable to partial resync with replica %s for lack of backlog (replica request was: %d)\n", conn.RemoteAddr(), psyncOffset)
+		return false
+	}
+	conn.queueReply([]byte(fmt.Sprintf("+CONTINUE %s\r\n", config.MasterReplID)))
+	conn.queueReply(missing)
+	addReplica(conn, "online")
+	conn.flush()
+	fmt.Printf("Partial resynchronization request from %s accepted. Sending %d bytes of backlog starting from offset %d.\n",
+		conn.RemoteAddr(), len(missing), psyncOffset)
+	return true
+}
+
+// 全量同步，把当前数据的快照发给 slave
+// 快照在 writeGate 写锁内生成：快照之前的写命令都在快照中，之后的都会传播给 slave
+// 复制偏移量在登记 slave 时读取，之间传播的只可能是不修改数据的命令
+// 生成和发送 RDB 期间传播给 slave 的命令先留在它的输出缓冲区，RDB 发完再发送（和 Redis 一样）
+func fullResync(conn *Client) {
+	replApplyLock.Lock()
+	defer replApplyLock.Unlock()
+	writeGate.Lock()
+	snapshot := snapshotStore()
+	config.Lock()
+	conn.queueReply([]byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n", config.MasterReplID, config.ReplOffset)))
+	conn.holdOutput()
+	addReplica(conn, "wait_bgsave") //登记到master的config.replicas,要保证原子性，多个slave节点下
+	config.Unlock()
+	writeGate.Unlock()
+	conn.flush()
+
+	fmt.Printf("Starting full resync with replica %s\n", conn.RemoteAddr())
+	go sendSnapshotToReplica(conn, snapshot)
+}
+
+// 把快照编码成 RDB，先保存到 RDB 文件（和 BGSAVE 一样），再以 $<length>\r\n<data> 的格式发给 slave
+func sendSnapshotToReplica(conn *Client, snapshot *storeSnapshot) {
+	start := time.Now()
+	setReplicaState(conn, "send_bulk")
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, snapshot, false); err != nil {
+		fmt.Println("Full resync failed, error encoding RDB:", err)
+		conn.Conn.Close()
+		return
+	}
+
+	rdbConfig.RLock()
+	dir, dbfilename := rdbConfig.dir, rdbConfig.dbfilename
+	rdbConfig.RUnlock()
+	if err := writeRDBFile(dir, dbfilename, buf.Bytes()); err != nil {
+		fmt.Println("Full resync failed, error saving RDB:", err)
+		conn.Conn.Close()
+		return
+	}
+	runOnExecutor(func() { rdbSaveDone(snapshot) })
+
+	payload := append([]byte(fmt.Sprintf("$%d\r\n", buf.Len())), buf.Bytes()...)
+	conn.releaseOutput(payload)
+	setReplicaState(conn, "online")
+	fmt.Printf("Synchronization with replica %s succeeded: %d bytes of RDB (%.3f seconds)\n",
+		conn.RemoteAddr(), buf.Len(), time.Since(start).Seconds())
+}
+
+// slave 载入全量同步收到的 RDB：清空现有数据后调用 load 载入，执行期间不处理其他命令
+// REPLICAOF 在 writeGate 写锁内停止复制，停止之后不会再清空数据
+func loadMasterRDB(link *masterLink, load func() (rdbLoadStats, error)) error {
+	var err error
+	runOnExecutor(func() {
+		writeGate.Lock()
+		defer writeGate.Unlock()
+		if link.stopped() {
+			err = errReplicationStopped
+			return
+		}
+
+		start := time.Now()
+		storeFlush()
+		var stats rdbLoadStats
+		stats, err = load()
+		if err != nil {
+			// 不保留载入了一部分的数据（边接收边载入时连接中途断开），下次同步从空数据开始
+			storeFlush()
+			return
+		}
+		storeResetDirty(storeDirty()) // 载入产生的修改不计入 save 规则
+		fmt.Printf("MASTER <-> REPLICA sync: loaded %d keys, %d expired keys skipped (%.3f seconds)\n",
+			stats.loaded, stats.expired, time.Since(start).Seconds())
+	})
+	return err
+}
+
+// 复制是否已经停止
+func (l *masterLink) stopped() bool {
+	select {
+	case <-l.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 记录当前连接，复制已经停止时返回 false
+func (l *masterLink) setConn(conn net.Conn) bool {
+	config.Lock()
+	defer config.Unlock()
+	if l.stopped() {
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+// 停止复制并关闭连接，正在进行的握手、同步和命令处理随之结束；调用时持有 config 锁
+func (l *masterLink) close() {
+	close(l.stop)
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// 成为 host:port 的 slave，在另一个 goroutine 中连接 master；调用时持有 config 锁
+func startReplication(host, port string) {
+	link := &masterLink{host: host, port: port, stop: make(chan struct{})}
+	config.masterLink = link
+	config.ReplicaOf = host
+	if port != "" {
+		config.ReplicaOf = host + " " + port
+	}
+	config.slave.Store(true)
+	config.masterLinkUp = false
+	go replicationLoop(link)
+}
+
+// 处理 REPLICAOF host port | REPLICAOF NO ONE：切换到新的 master，或者停止复制成为 master
+// 切换期间持有 writeGate 写锁，没有正在执行的写命令，每条写命令都按切换前或切换后的角色传播
+func handleREPLICAOF(args []string) string {
+	if len(args) != 2 {
+		return "-ERR wrong number of arguments for 'replicaof' command\r\n"
+	}
+	writeGate.Lock()
+	defer writeGate.Unlock()
+	config.Lock()
+	defer config.Unlock()
+
+	if strings.ToUpper(args[0]) == "NO" && strings.ToUpper(args[1]) == "ONE" {
+		if config.masterLink == nil {
+			return "+OK\r\n"
+		}
+		// 保留数据，生成新的复制 ID；之前和这个 slave 同步的 slave 持有旧 ID，
+		// 断开后重新 PSYNC 可以部分同步并得到新 ID
+		config.masterLink.close()
+		config.masterLink = nil
+		config.ReplicaOf = ""
+		config.slave.Store(false)
+		config.masterLinkUp = false
+		config.masterSyncInProgress = false
+		shiftReplicationID(newReplicationID())
+		disconnectReplicas()
+		fmt.Println("MASTER MODE enabled")
+		return "+OK\r\n"
+	}
+
+	host, port := args[0], args[1]
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return "-ERR Invalid master port\r\n"
+	}
+	if link := config.masterLink; link != nil {
+		if link.host == host && link.port == port {
+			return "+OK Already connected to specified master\r\n"
+		}
+		link.close()
+	}
+	// 自己的 slave 断开后重新同步，得到新 master 的数据
+	disconnectReplicas()
+	startReplication(host, port)
+	fmt.Printf("REPLICAOF %s:%s enabled\n", host, port)
+	return "+OK\r\n"
+}
+
+// slave 和 master 保持复制连接：握手失败或连接断开后等待一段时间重连，等待时间逐次加倍，复制停止后返回
+func replicationLoop(link *masterLink) {
+	backoff := replReconnectMin
+	for !link.stopped() {
+		conn, reader, err := handshakeWithMaster(link)
+		if err != nil {
+			if link.stopped() {
+				break
+			}
+			fmt.Printf("Error handshaking with master, retrying in %v: %v\n", backoff, err)
+			select {
+			case <-link.stop:
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, replReconnectMax)
+			continue
+		}
+		backoff = replReconnectMin
+		setMasterLinkUp(link, true)
+
+		// 连接期间每秒向 master 发送确认
+		done := make(chan struct{})
+		go sendAcksToMaster(conn, done)
+		handleMasterCommands(link, conn, reader)
+		close(done)
+		conn.Close()
+		setMasterLinkUp(link, false)
+		if !link.stopped() {
+			fmt.Println("Connection with master lost, reconnecting")
+		}
+	}
+	fmt.Printf("Replication with master %s %s stopped\n", link.host, link.port)
+}
+
+// 设置和 master 的连接状态，复制已经停止时不修改
+func setMasterLinkUp(link *masterLink, up bool) {
+	config.Lock()
+	if config.masterLink == link {
+		config.masterLinkUp = up
+		config.masterLastIO = time.Now()
+	}
+	config.Unlock()
+}
+
+// 发送 PSYNC <replid> <offset+1>：replid 和 offset 是上次从 master 同步到的位置（从未同步时是自己的，master 不会接受），
+// master 回复 +CONTINUE 时从断开的位置继续，回复 +FULLRESYNC 时载入 master 发来的 RDB
+func psyncWithMaster(link *masterLink, conn net.Conn, reader *bufio.Reader) error {
+	config.Lock()
+	replID, offset := config.MasterReplID, config.ReplOffset+1
+	config.Unlock()
+	if _, err := conn.Write([]byte(encodeArray([]string{"PSYNC", replID, strconv.FormatInt(offset, 10)}))); err != nil {
+		return fmt.Errorf("error sending PSYNC command: %v", err)
+	}
+
+	// 读取PSYNC响应并检查
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading response to PSYNC: %v", err)
+	}
+	parts := strings.Fields(response)
+	switch {
+	case len(parts) >= 1 && parts[0] == "+CONTINUE":
+		// master 的复制 ID 变了（例如发生了故障转移），旧 ID 作为 replid2 保留
+		config.Lock()
+		defer config.Unlock()
+		if link.stopped() {
+			return errReplicationStopped
+		}
+		// 新 ID 继续传给下级 slave：断开它们，重新 PSYNC 时用 replid2 部分同步并得到新 ID
+		if len(parts) >= 2 && parts[1] != config.MasterReplID {
+			shiftReplicationID(parts[1])
+			disconnectReplicas()
+		}
+		fmt.Println("Successful partial resynchronization with master")
+		return nil
+	case len(parts) >= 3 && parts[0] == "+FULLRESYNC":
+	default:
+		return fmt.Errorf("unexpected response to PSYNC command: %s", strings.TrimSpace(response))
+	}
+
+	// 提取 REPL_ID 和偏移量
+	masterReplID := parts[1]
+	masterOffset, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid FULLRESYNC response: %s", strings.TrimSpace(response))
+	}
+	fmt.Printf("Full resync from master: %s:%d\n", masterReplID, masterOffset)
+	config.Lock()
+	config.masterSyncInProgress = true
+	config.Unlock()
+	defer func() {
+		config.Lock()
+		config.masterSyncInProgress = false
+		config.Unlock()
+	}()
+
+	// 读取 RDB 文件长度部分，master 生成 RDB 期间可能先发送空行保持连接
+	var rdbLengthLine string
+	for rdbLengthLine == "" {
+		rdbLengthLine, err = reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("error reading RDB length: %v", err)
+		}
+		rdbLengthLine = strings.TrimRight(rdbLengthLine, "\r\n")
+	}
+	// RDB 以 $<length>\r\n<data> 的格式发送，无盘同步时是 $EOF:<mark>\r\n<data><mark>
+	if err := receiveMasterRDB(link, reader, rdbLengthLine); err != nil {
+		return fmt.Errorf("error loading RDB received from master: %v", err)
+	}
+
+	// 使用 master 的复制 ID 和偏移量，积压缓冲区从这个偏移量重新开始
+	config.Lock()
+	if link.stopped() {
+		config.Unlock()
+		return errReplicationStopped
+	}
+	config.MasterReplID = masterReplID
+	config.MasterReplID2 = strings.Repeat("0", 40)
+	config.SecondReplOffset = -1
+	config.ReplOffset = masterOffset
+	config.backlog = newReplBacklog(int64(len(config.backlog.buf)), masterOffset)
+	// 数据已经替换，下级 slave 需要重新全量同步
+	disconnectReplicas()
+	config.Unlock()
+
+	// 载入的数据通过重写进入 AOF 的 base 文件
+	if aofEnabled() {
+		if err := rewriteAppendOnlyFileBackground(); err != nil {
+			fmt.Println("Error rewriting AOF after sync:", err)
+		}
+	}
+	return nil
+}
+
+// INFO replication：master 列出连接的 slave，slave 显示和 master 的连接状态
+func replicationInfo() string {
+	config.Lock()
+	defer config.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "role:%s\r\n", getRole())
+	if link := config.masterLink; link != nil {
+		masterHost, masterPort := link.host, link.port
+		linkStatus, lastIO := "down", -1
+		if config.masterLinkUp {
+			linkStatus, lastIO = "up", int(time.Since(config.masterLastIO).Seconds())
+		}
+		syncInProgress := 0
+		if config.masterSyncInProgress {
+			syncInProgress = 1
+		}
+		fmt.Fprintf(&b, "master_host:%s\r\nmaster_port:%s\r\nmaster_link_status:%s\r\nmaster_last_io_seconds_ago:%d\r\nmaster_sync_in_progress:%d\r\nslave_repl_offset:%d\r\n",
+			masterHost, masterPort, linkStatus, lastIO, syncInProgress, config.ReplOffset)
+	}
+	fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(config.replicas))
+	for i, replica := range config.replicas {
+		host, _, err := net.SplitHostPort(replica.RemoteAddr().String())
+		if err != nil {
+			host = replica.RemoteAddr().String() // Unix socket
+		}
+		fmt.Fprintf(&b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, host, replica.repl.listeningPort, replica.repl.state, replica.repl.ackOffset, int(time.Since(replica.repl.ackTime).Seconds()))
+	}
+	fmt.Fprintf(&b, "master_replid:%s\r\nmaster_replid2:%s\r\nmaster_repl_offset:%d\r\nsecond_repl_offset:%d\r\n"+
+		"repl_backlog_active:1\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d",
+		config.MasterReplID, config.MasterReplID2, config.ReplOffset, config.SecondReplOffset,
+		len(config.backlog.buf), config.backlog.start+1, config.backlog.histlen)
+	return b.String()
+}
@@ -2,55 +2,291 @@ package main
 
 import (
 	// "fmt"
-	"path/filepath"
-	"sync"
 	"time"
 	// "honnef.co/go/tools/pattern"
 	// "errors"
 	"strconv"
 	"strings"
 	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/rdb"
 )
 
+// keyspace 的一个分片：key 按哈希值分到固定的分片，每个分片有自己的锁和过期时间表，
+// 不同分片上的命令可以在多个核上并行执行
+type storeShard struct {
+	keyspaceMutex               // 保护下面的数据，单线程模式下不加锁，见 executor.go
+	gate          keyspaceMutex // 事务锁：普通命令持有读锁，EXEC 持有写锁，事务执行期间其他命令不会访问涉及的分片
+	data          map[string]string
+	expires       map[string]int64 // 过期时间（毫秒时间戳），适用于所有类型的 key
+	streams       map[string][]StreamEntry
+	groups        map[string][]StreamConsumerGroup // 流的消费者组
+	lists         map[string][]string
+	sets          map[string]map[string]bool
+	hashes        map[string]map[string]string
+	zsets         map[string]map[string]float64 // 成员 -> 分数
+}
+
 // 内存存储 key-value 数据
 var store = struct {
-	sync.RWMutex
-	data    map[string]string
-	expires map[string]int64 // 过期时间（毫秒时间戳）
-	streams map[string][]StreamEntry
-}{data: make(map[string]string), expires: make(map[string]int64), streams: make(map[string][]StreamEntry)}
+	shards []*storeShard
+	count  int   // 分片数（-keyspace-shards），启动后不变
+	dirty  int64 // 上次保存 RDB 之后的修改次数，原子操作
+}{
+	count: 16,
+}
+
+// StreamEntry 代表 Redis Stream 的单个条目，和 RDB 中的表示相同
+type StreamEntry = rdb.StreamEntry
+
+// 流的消费者组（包括 PEL 和消费者）
+type StreamConsumerGroup = rdb.ConsumerGroup
+
+func newStoreShard() *storeShard {
+	shard := &storeShard{}
+	shard.resetLocked()
+	return shard
+}
+
+// 清空分片中的数据，调用时需要持有写锁
+func (shard *storeShard) resetLocked() {
+	shard.data = make(map[string]string)
+	shard.expires = make(map[string]int64)
+	shard.streams = make(map[string][]StreamEntry)
+	shard.groups = make(map[string][]StreamConsumerGroup)
+	shard.lists = make(map[string][]string)
+	shard.sets = make(map[string]map[string]bool)
+	shard.hashes = make(map[string]map[string]string)
+	shard.zsets = make(map[string]map[string]float64)
+}
+
+// 按 store.count 创建分片，启动时调用一次
+func initStore() {
+	store.shards = make([]*storeShard, store.count)
+	for i := range store.shards {
+		store.shards[i] = newStoreShard()
+	}
+}
+
+// key 所在分片的编号（FNV-1a 哈希）
+func shardIndex(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(len(store.shards)))
+}
+
+// key 所在的分片
+func shardFor(key string) *storeShard {
+	return store.shards[shardIndex(key)]
+}
+
+// keys 涉及的分片，去重并按编号从小到大排序
+// 需要同时锁住多个分片时总是按这个顺序加锁，不同协程之间不会死锁
+func shardsFor(keys []string) []*storeShard {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		index := shardIndex(key)
+		if !seen[index] {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	shards := make([]*storeShard, len(indexes))
+	for i, index := range indexes {
+		shards[i] = store.shards[index]
+	}
+	return shards
+}
+
+// 按顺序对 keys 所在的分片加写锁，返回解锁函数（多 key 命令使用）
+func lockShards(keys []string) func() {
+	shards := shardsFor(keys)
+	for _, shard := range shards {
+		shard.Lock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].Unlock()
+		}
+	}
+}
+
+// 按顺序对 keys 所在的分片加读锁，返回解锁函数
+func rlockShards(keys []string) func() {
+	shards := shardsFor(keys)
+	for _, shard := range shards {
+		shard.RLock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].RUnlock()
+		}
+	}
+}
+
+// 按顺序对所有分片加读锁，得到时间点一致的视图（快照）
+func rlockAllShards() func() {
+	for _, shard := range store.shards {
+		shard.RLock()
+	}
+	return func() {
+		for i := len(store.shards) - 1; i >= 0; i-- {
+			store.shards[i].RUnlock()
+		}
+	}
+}
+
+// 普通命令执行期间持有 keys 所在分片的事务锁（读锁）
+func enterShardGates(keys []string) func() {
+	shards := shardsFor(keys)
+	for _, shard := range shards {
+		shard.gate.RLock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].gate.RUnlock()
+		}
+	}
+}
 
-// StreamEntry 代表 Redis Stream 的单个条目
-type StreamEntry struct {
-	ID    string
-	Fields map[string]string
+// 独占所有分片的事务锁，DEBUG RELOAD、DEBUG IMPORT 执行期间其他命令不会看到一半的结果
+func lockAllShardGates() func() {
+	for _, shard := range store.shards {
+		shard.gate.Lock()
+	}
+	return func() {
+		for i := len(store.shards) - 1; i >= 0; i-- {
+			store.shards[i].gate.Unlock()
+		}
+	}
 }
 
+// EXEC 执行期间独占事务涉及的所有分片：先按顺序拿到事务锁，再执行命令（命令内部只会加数据锁）
+func lockShardGates(keys []string) func() {
+	shards := shardsFor(keys)
+	for _, shard := range shards {
+		shard.gate.Lock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].gate.Unlock()
+		}
+	}
+}
+
+// 增加修改计数
+func markDirty(n int64) {
+	atomic.AddInt64(&store.dirty, n)
+}
 
 // 设置 key-value，并处理过期时间
 func storeSet(key, value string, ttl int64) {
-	store.Lock()
-	store.data[key] = value
+	shard := shardFor(key)
+	shard.Lock()
+	shard.setLocked(key, value, ttl)
+	shard.Unlock()
+	markDirty(1)
+	trackingInvalidateKey(key)
+}
+
+// 设置字符串值，ttl 为 0 表示不过期，调用时需要持有写锁
+func (shard *storeShard) setLocked(key, value string, ttl int64) {
+	shard.deleteKeyLocked(key) // SET 会覆盖任何类型的旧值
+	shard.data[key] = value
 	// fmt.Println("storeSet key:", key, "value:", value, "ttl:", ttl)
 	if ttl > 0 {
-		store.expires[key] = ttl
+		shard.expires[key] = ttl
 	} else {
-		delete(store.expires, key) // 确保无 PX 参数时删除可能的旧过期时间
+		delete(shard.expires, key) // 确保无 PX 参数时删除可能的旧过期时间
+	}
+}
+
+// 同时设置多个 key（MSET），所有 key 所在的分片按顺序加锁，其他命令看不到只设置了一部分的状态
+func storeMSet(pairs []string) {
+	keys := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		keys = append(keys, pairs[i])
+	}
+	unlock := lockShards(keys)
+	for i := 0; i < len(pairs); i += 2 {
+		shardFor(pairs[i]).setLocked(pairs[i], pairs[i+1], 0)
+	}
+	unlock()
+	markDirty(int64(len(keys)))
+	for _, key := range keys {
+		trackingInvalidateKey(key)
+	}
+}
+
+// 把 src 改名为 dst（RENAME），值和过期时间一起移动，src 不存在时返回 false
+func storeRename(src, dst string) bool {
+	unlock := lockShards([]string{src, dst})
+	srcShard, dstShard := shardFor(src), shardFor(dst)
+	if srcShard.expiredLocked(src) || srcShard.typeLocked(src) == "none" {
+		unlock()
+		return false
+	}
+	if src != dst {
+		dstShard.deleteKeyLocked(dst)
+		delete(dstShard.expires, dst)
+		if value, ok := srcShard.data[src]; ok {
+			dstShard.data[dst] = value
+		}
+		if entries, ok := srcShard.streams[src]; ok {
+			dstShard.streams[dst] = entries
+		}
+		if groups, ok := srcShard.groups[src]; ok {
+			dstShard.groups[dst] = groups
+		}
+		if list, ok := srcShard.lists[src]; ok {
+			dstShard.lists[dst] = list
+		}
+		if set, ok := srcShard.sets[src]; ok {
+			dstShard.sets[dst] = set
+		}
+		if hash, ok := srcShard.hashes[src]; ok {
+			dstShard.hashes[dst] = hash
+		}
+		if zset, ok := srcShard.zsets[src]; ok {
+			dstShard.zsets[dst] = zset
+		}
+		if expireTime, ok := srcShard.expires[src]; ok {
+			dstShard.expires[dst] = expireTime
+		}
+		srcShard.deleteKeyLocked(src)
+		delete(srcShard.expires, src)
 	}
-	store.Unlock()
+	unlock()
+	markDirty(1)
+	trackingInvalidateKey(src)
+	trackingInvalidateKey(dst)
+	return true
+}
+
+// key 设置了过期时间并且已经过期，调用时需要持有锁
+func (shard *storeShard) expiredLocked(key string) bool {
+	expireTime, hasExpiry := shard.expires[key]
+	return hasExpiry && time.Now().UnixNano()/1e6 >= expireTime
 }
 
 // 获取 key 的值（考虑过期情况）
 func storeGet(key string) (string, bool) {
-	store.RLock()
-	value, exists := store.data[key]
-	expireTime, hasExpiry := store.expires[key]
-	store.RUnlock()
+	shard := shardFor(key)
+	shard.RLock()
+	value, exists := shard.data[key]
+	expireTime, hasExpiry := shard.expires[key]
+	shard.RUnlock()
 
 	if exists {
 		if hasExpiry && time.Now().UnixNano()/1e6 >= expireTime {
-			// 密钥已过期
-			storeDelete(key)
+			// 密钥已过期，当作不存在；删除由 master 在命令执行后进行并传播 DEL，见 expire.go
 			return "", false
 		}
 		return value, true
@@ -59,40 +295,372 @@ func storeGet(key string) (string, bool) {
 	return "", false
 }
 
-// 删除 key
-func storeDelete(key string) {
-	store.Lock()
-	delete(store.data, key)
-	delete(store.expires, key)
-	store.Unlock()
+// 获取 key 的过期时间（毫秒时间戳），没有设置过期时返回 false
+func storeExpireTime(key string) (int64, bool) {
+	shard := shardFor(key)
+	shard.RLock()
+	defer shard.RUnlock()
+	expireTime, hasExpiry := shard.expires[key]
+	return expireTime, hasExpiry
 }
 
-// 返回所有的 key（处理 KEYS (pattern) 命令）
-func storeKeys(pattern string) []string {
-	store.RLock()
-	defer store.RUnlock()
+// 设置 key 的过期时间（毫秒时间戳），key 不存在时返回 false
+func storeSetExpire(key string, expireAt int64) bool {
+	shard := shardFor(key)
+	shard.Lock()
+	// 所有类型的 key 都可以设置过期时间，已经过期的 key 当作不存在
+	exists := !shard.expiredLocked(key) && shard.typeLocked(key) != "none"
+	if exists {
+		shard.expires[key] = expireAt
+	}
+	shard.Unlock()
+	if exists {
+		markDirty(1)
+		trackingInvalidateKey(key)
+	}
+	return exists
+}
+
+// 某一时刻的数据快照，用于 AOF 重写等后台任务
+type storeSnapshot struct {
+	data    map[string]string
+	expires map[string]int64
+	streams map[string][]StreamEntry
+	groups  map[string][]StreamConsumerGroup
+	lists   map[string][]string
+	sets    map[string]map[string]bool
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	dirty   int64 // 生成快照时的修改计数
+}
+
+// 快照中 key 的总数
+func (s *storeSnapshot) keyCount() int {
+	return len(s.data) + len(s.streams) + len(s.lists) + len(s.sets) + len(s.hashes) + len(s.zsets)
+}
+
+// 在所有分片的读锁下复制整个 store，已过期的 key 不会出现在快照中
+// 流的条目写入后不会再修改，只复制切片即可
+func snapshotStore() *storeSnapshot {
+	unlock := rlockAllShards()
+	defer unlock()
+
+	now := time.Now().UnixNano() / 1e6
+	snapshot := &storeSnapshot{
+		data:    make(map[string]string),
+		expires: make(map[string]int64),
+		streams: make(map[string][]StreamEntry),
+		groups:  make(map[string][]StreamConsumerGroup),
+		lists:   make(map[string][]string),
+		sets:    make(map[string]map[string]bool),
+		hashes:  make(map[string]map[string]string),
+		zsets:   make(map[string]map[string]float64),
+		dirty:   atomic.LoadInt64(&store.dirty),
+	}
+	for _, shard := range store.shards {
+		shard.copyInto(snapshot, now)
+	}
+	return snapshot
+}
+
+// 把分片中没有过期的 key 复制到快照，调用时需要持有读锁
+func (shard *storeShard) copyInto(snapshot *storeSnapshot, now int64) {
+	for key, expireTime := range shard.expires {
+		if expireTime > now {
+			snapshot.expires[key] = expireTime
+		}
+	}
+	expired := func(key string) bool {
+		expireTime, hasExpiry := shard.expires[key]
+		return hasExpiry && now >= expireTime
+	}
+	for key, value := range shard.data {
+		if !expired(key) {
+			snapshot.data[key] = value
+		}
+	}
+	for key, entries := range shard.streams {
+		if !expired(key) {
+			snapshot.streams[key] = append([]StreamEntry(nil), entries...)
+			if groups, ok := shard.groups[key]; ok {
+				snapshot.groups[key] = append([]StreamConsumerGroup(nil), groups...)
+			}
+		}
+	}
+	for key, list := range shard.lists {
+		if !expired(key) {
+			snapshot.lists[key] = append([]string(nil), list...)
+		}
+	}
+	for key, set := range shard.sets {
+		if !expired(key) {
+			copied := make(map[string]bool, len(set))
+			for member := range set {
+				copied[member] = true
+			}
+			snapshot.sets[key] = copied
+		}
+	}
+	for key, hash := range shard.hashes {
+		if !expired(key) {
+			copied := make(map[string]string, len(hash))
+			for field, value := range hash {
+				copied[field] = value
+			}
+			snapshot.hashes[key] = copied
+		}
+	}
+	for key, zset := range shard.zsets {
+		if !expired(key) {
+			copied := make(map[string]float64, len(zset))
+			for member, score := range zset {
+				copied[member] = score
+			}
+			snapshot.zsets[key] = copied
+		}
+	}
+}
+
+// 当前修改计数
+func storeDirty() int64 {
+	return atomic.LoadInt64(&store.dirty)
+}
+
+// 快照保存成功后，减去快照包含的修改次数
+func storeResetDirty(saved int64) {
+	markDirty(-saved)
+}
+
+// 删除 key，返回 key 是否存在
+func storeDelete(key string) bool {
+	shard := shardFor(key)
+	shard.Lock()
+	existed := shard.deleteKeyLocked(key)
+	delete(shard.expires, key)
+	shard.Unlock()
+	if existed {
+		markDirty(1)
+	}
+	trackingInvalidateKey(key)
+	return existed
+}
+
+// keys 中是否有已经过期、还没有删除的 key
+func storeHasExpired(keys []string) bool {
+	for _, key := range keys {
+		shard := shardFor(key)
+		shard.RLock()
+		expired := shard.expiredLocked(key)
+		shard.RUnlock()
+		if expired {
+			return true
+		}
+	}
+	return false
+}
+
+// 删除 keys 中已经过期的 key，返回删除的 key
+func storeDeleteExpired(keys []string) []string {
+	var deleted []string
+	for _, key := range keys {
+		shard := shardFor(key)
+		shard.Lock()
+		expired := shard.expiredLocked(key)
+		if expired {
+			shard.deleteKeyLocked(key)
+			delete(shard.expires, key)
+		}
+		shard.Unlock()
+		if expired {
+			markDirty(1)
+			trackingInvalidateKey(key)
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted
+}
+
+// 从分片的过期时间表中抽查最多 samples 个 key，返回其中已经过期的（map 的遍历顺序是随机的）
+func (shard *storeShard) sampleExpired(samples int) []string {
+	shard.RLock()
+	defer shard.RUnlock()
+	var expired []string
+	checked := 0
+	for key := range shard.expires {
+		if checked == samples {
+			break
+		}
+		checked++
+		if shard.expiredLocked(key) {
+			expired = append(expired, key)
+		}
+	}
+	return expired
+}
+
+// 清空所有数据（DEBUG RELOAD 重新载入之前），修改计数保持不变
+// 调用时需要持有 writeGate 写锁，清空期间不会有新的写入
+func storeFlush() {
+	var keys []string
+	for _, shard := range store.shards {
+		shard.Lock()
+		keys = append(keys, shard.keysLocked()...)
+		shard.resetLocked()
+		shard.Unlock()
+	}
+	for _, key := range keys {
+		trackingInvalidateKey(key)
+	}
+}
+
+// 分片中所有类型的 key，调用时需要持有锁
+func (shard *storeShard) keysLocked() []string {
+	var keys []string
+	for key := range shard.data {
+		keys = append(keys, key)
+	}
+	for key := range shard.streams {
+		keys = append(keys, key)
+	}
+	for key := range shard.lists {
+		keys = append(keys, key)
+	}
+	for key := range shard.sets {
+		keys = append(keys, key)
+	}
+	for key := range shard.hashes {
+		keys = append(keys, key)
+	}
+	for key := range shard.zsets {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 从所有类型的表中删除 key（不包括过期时间），返回 key 是否存在，调用时需要持有写锁
+func (shard *storeShard) deleteKeyLocked(key string) bool {
+	exists := shard.typeLocked(key) != "none"
+	delete(shard.data, key)
+	delete(shard.streams, key)
+	delete(shard.groups, key)
+	delete(shard.lists, key)
+	delete(shard.sets, key)
+	delete(shard.hashes, key)
+	delete(shard.zsets, key)
+	return exists
+}
+
+// key 的类型：string、list、set、zset、hash、stream，不存在时为 none，调用时需要持有锁
+func (shard *storeShard) typeLocked(key string) string {
+	if _, exists := shard.data[key]; exists {
+		return "string"
+	}
+	if _, exists := shard.lists[key]; exists {
+		return "list"
+	}
+	if _, exists := shard.sets[key]; exists {
+		return "set"
+	}
+	if _, exists := shard.zsets[key]; exists {
+		return "zset"
+	}
+	if _, exists := shard.hashes[key]; exists {
+		return "hash"
+	}
+	if _, exists := shard.streams[key]; exists {
+		return "stream"
+	}
+	return "none"
+}
+
+// key 的类型，不存在时为 none
+func storeType(key string) string {
+	shard := shardFor(key)
+	shard.RLock()
+	defer shard.RUnlock()
+	return shard.typeLocked(key)
+}
+
+// 载入 RDB 中的一个 key，value 的类型决定 key 的类型，expireAt 为 0 表示不过期
+func storeLoadValue(key string, value interface{}, expireAt int64) error {
+	shard := shardFor(key)
+	shard.Lock()
+	shard.deleteKeyLocked(key)
+	switch v := value.(type) {
+	case string:
+		shard.data[key] = v
+	case []string:
+		shard.lists[key] = v
+	case map[string]bool:
+		shard.sets[key] = v
+	case map[string]string:
+		shard.hashes[key] = v
+	case map[string]float64:
+		shard.zsets[key] = v
+	case *rdb.Stream:
+		shard.streams[key] = v.Entries
+		if len(v.Groups) > 0 {
+			shard.groups[key] = v.Groups
+		}
+	default:
+		shard.Unlock()
+		return fmt.Errorf("unsupported value for key '%s'", key)
+	}
+	if expireAt > 0 {
+		shard.expires[key] = expireAt
+	} else {
+		delete(shard.expires, key)
+	}
+	shard.Unlock()
+	markDirty(1)
+	trackingInvalidateKey(key)
+	return nil
+}
 
+// 返回所有的 key（处理 KEYS (pattern) 命令），逐个分片加读锁
+func storeKeys(pattern string) []string {
 	var keys []string
-	for key := range store.data {
-		match, _ := filepath.Match(pattern, key)
-		if match {
+	match := func(key string) {
+		if stringMatch(pattern, key) {
 			keys = append(keys, key)
 		}
 	}
+	for _, shard := range store.shards {
+		shard.RLock()
+		for key := range shard.data {
+			match(key)
+		}
+		for key := range shard.streams {
+			match(key)
+		}
+		for key := range shard.lists {
+			match(key)
+		}
+		for key := range shard.sets {
+			match(key)
+		}
+		for key := range shard.hashes {
+			match(key)
+		}
+		for key := range shard.zsets {
+			match(key)
+		}
+		shard.RUnlock()
+	}
 	return keys
 }
 
 
 // 生成新 ID 时根据时间和序列号递增
-func generateStreamID(stream string) string {
+func generateStreamID(entries []StreamEntry) string {
 	// 获取当前时间（毫秒级 Unix 时间戳）
 	now := time.Now().UnixNano() / int64(time.Millisecond)
 	timePart := strconv.FormatInt(now, 10)
 
 	// 获取现有流中的最后一个条目的序列号
 	lastSeq := int64(0)
-	if len(store.streams[stream]) > 0 {
-		lastEntry := store.streams[stream][len(store.streams[stream])-1]
+	if len(entries) > 0 {
+		lastEntry := entries[len(entries)-1]
 		lastTime, lastSeq := parseID(lastEntry.ID)
 		if lastTime == now {
 			// 如果时间部分相同，则递增序列号
@@ -139,29 +707,36 @@ func generateSequenceID(entries []StreamEntry, id string) string {
 
 // xadd 函数，处理流的插入并验证 ID
 func xadd(stream string, id string, fields map[string]string) string {
-	store.Lock()
-	defer store.Unlock()
+	added := false
+	defer func() {
+		// 在释放锁之后再发送失效消息
+		if added {
+			trackingInvalidateKey(stream)
+		}
+	}()
+	shard := shardFor(stream)
+	shard.Lock()
+	defer shard.Unlock()
 
 	// 确保 key 是 stream 类型
-	if _, exists := store.streams[stream]; !exists {
-		store.streams[stream] = []StreamEntry{}
+	if _, exists := shard.streams[stream]; !exists {
+		shard.streams[stream] = []StreamEntry{}
 	}
 
 	// 处理自动生成序列号的 ID
 	if strings.Contains(id, "-*") {
-		id = generateSequenceID(store.streams[stream], id)
+		id = generateSequenceID(shard.streams[stream], id)
 	}
 
 	// 处理自动 ID（时间和序列号）
 	if id == "*" {
-		id = generateStreamID(stream)
+		id = generateStreamID(shard.streams[stream])
 	}
 
 
 	// 如果流不为空，验证 ID
-	if len(store.streams[stream]) > 0 {
-		lastEntry := store.streams[stream][len(store.streams[stream])-1]
-		fmt.Println("ID IS:", id, "LAST ID IS:", lastEntry.ID)
+	if len(shard.streams[stream]) > 0 {
+		lastEntry := shard.streams[stream][len(shard.streams[stream])-1]
 		if !isValidID(id, lastEntry.ID) {
 			return "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"
 		}
@@ -172,7 +747,9 @@ func xadd(stream string, id string, fields map[string]string) string {
 		ID:     id,
 		Fields: fields,
 	}
-	store.streams[stream] = append(store.streams[stream], entry)
+	shard.streams[stream] = append(shard.streams[stream], entry)
+	markDirty(1)
+	added = true
 
 	// 返回 ID
 	return id
@@ -199,7 +776,6 @@ func isValidID(newID, lastID string) bool {
 // 解析 ID 获取时间和序列号
 func parseID(id string) (int64, int64) {
 	parts := strings.Split(id, "-")
-	fmt.Println("len(parts):", len(parts))
 	// 处理异常情况：ID 不符合格式
 	if len(parts) != 2 {
 		fmt.Printf("Warning: Invalid ID format '%s', returning (0,0)\n", id)
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLS 配置
+var tlsConfig = struct {
+	port        int    // TLS 端口，0 表示不监听
+	certFile    string // 证书，监听和作为客户端连接 master 时都使用
+	keyFile     string
+	caCertFile  string // 校验对端证书的 CA
+	authClients string // 是否要求客户端证书：yes|no|optional
+	replication string // slave 是否用 TLS 连接 master：yes|no
+
+	server *tls.Config // 监听使用
+	client *tls.Config // 连接 master 使用
+}{authClients: "yes", replication: "no"}
+
+// 检查 TLS 参数并载入证书，没有开启 TLS 时什么也不做
+func initTLS() error {
+	switch tlsConfig.authClients {
+	case "yes", "no", "optional":
+	default:
+		return fmt.Errorf("invalid tls-auth-clients value: %s", tlsConfig.authClients)
+	}
+	switch tlsConfig.replication {
+	case "yes", "no":
+	default:
+		return fmt.Errorf("invalid tls-replication value: %s", tlsConfig.replication)
+	}
+	if tlsConfig.port == 0 && tlsConfig.replication == "no" {
+		return nil
+	}
+
+	if tlsConfig.certFile == "" || tlsConfig.keyFile == "" {
+		return fmt.Errorf("tls-cert-file and tls-key-file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(tlsConfig.certFile, tlsConfig.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %v", err)
+	}
+
+	var caPool *x509.CertPool
+	if tlsConfig.caCertFile != "" {
+		pem, err := os.ReadFile(tlsConfig.caCertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", tlsConfig.caCertFile)
+		}
+	}
+
+	tlsConfig.server = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    caPool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch tlsConfig.authClients {
+	case "yes":
+		tlsConfig.server.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.server.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if tlsConfig.server.ClientAuth != tls.NoClientCert && caPool == nil {
+		return fmt.Errorf("tls-ca-cert-file is required when tls-auth-clients is %s", tlsConfig.authClients)
+	}
+
+	// 连接 master 时出示同一张证书，master 要求客户端证书时也能通过
+	tlsConfig.client = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return nil
+}
+
+// 监听 TLS 端口
+func listenTLS() (net.Listener, error) {
+	return tls.Listen("tcp", fmt.Sprintf(":%d", tlsConfig.port), tlsConfig.server)
+}
+
+// 连接 master，tls-replication 开启时使用 TLS；端口为空时 masterHost 是 master 的 Unix socket（不使用 TLS）
+func dialMaster(masterHost, masterPort string) (net.Conn, error) {
+	if masterPort == "" {
+		return net.Dial("unix", masterHost)
+	}
+	address := net.JoinHostPort(masterHost, masterPort)
+	if tlsConfig.replication != "yes" {
+		return net.Dial("tcp", address)
+	}
+	cfg := tlsConfig.client.Clone()
+	cfg.ServerName = masterHost
+	conn, err := tls.Dial("tcp", address, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
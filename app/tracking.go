@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RESP2 下接收失效消息的频道
+const invalidateChannel = "__redis__:invalidate"
+
+// 客户端缓存（CLIENT TRACKING）状态
+type trackingState struct {
+	enabled  bool
+	redirect int64    // 失效消息转发到的客户端 ID，0 表示发给自己
+	bcast    bool     // 广播模式：按前缀通知，不记录读过的 key
+	optin    bool     // 只记录 CLIENT CACHING yes 之后的那条命令读的 key
+	optout   bool     // 不记录 CLIENT CACHING no 之后的那条命令读的 key
+	prefixes []string // 广播模式注册的前缀
+	caching  string   // CLIENT CACHING 对下一条命令的设置："yes"、"no" 或空
+}
+
+// 服务端记录的失效表
+var trackingTable = struct {
+	sync.Mutex
+	keys     map[string]map[int64]bool // 默认模式：key -> 读过它的客户端
+	prefixes map[string]map[int64]bool // 广播模式：前缀 -> 注册了该前缀的客户端
+}{keys: make(map[string]map[int64]bool), prefixes: make(map[string]map[int64]bool)}
+
+// 处理 CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT]
+func handleClientTracking(client *Client, args []string) string {
+	if len(args) < 1 {
+		return "-ERR wrong number of arguments for 'client|tracking' command\r\n"
+	}
+
+	var redirect int64
+	var prefixes []string
+	bcast, optin, optout := false, false, false
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return "-ERR syntax error\r\n"
+			}
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range\r\n"
+			}
+			if _, exists := lookupClient(id); !exists || id == client.ID {
+				return "-ERR The client ID you want redirect to does not exist\r\n"
+			}
+			redirect = id
+			i++
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return "-ERR syntax error\r\n"
+			}
+			prefixes = append(prefixes, args[i+1])
+			i++
+		case "BCAST":
+			bcast = true
+		case "OPTIN":
+			optin = true
+		case "OPTOUT":
+			optout = true
+		default:
+			return "-ERR syntax error\r\n"
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ON":
+	case "OFF":
+		disableTracking(client)
+		return "+OK\r\n"
+	default:
+		return "-ERR syntax error\r\n"
+	}
+
+	if len(prefixes) > 0 && !bcast {
+		return "-ERR PREFIX option requires BCAST mode to be enabled\r\n"
+	}
+	if optin && optout {
+		return "-ERR You can't use both OPTIN and OPTOUT\r\n"
+	}
+	if bcast && (optin || optout) {
+		return "-ERR OPTIN and OPTOUT are not compatible with BCAST\r\n"
+	}
+
+	client.Lock()
+	state := &client.tracking
+	if state.enabled && state.bcast != bcast {
+		client.Unlock()
+		return "-ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.\r\n"
+	}
+	if state.enabled && (state.optin != optin || state.optout != optout) {
+		client.Unlock()
+		return "-ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.\r\n"
+	}
+	if bcast && len(prefixes) == 0 {
+		prefixes = []string{""} // 不指定前缀时匹配所有 key
+	}
+	state.enabled = true
+	state.redirect = redirect
+	state.bcast = bcast
+	state.optin = optin
+	state.optout = optout
+	state.prefixes = append(state.prefixes, prefixes...)
+	state.caching = ""
+	client.Unlock()
+
+	trackingTable.Lock()
+	for _, prefix := range prefixes {
+		if trackingTable.prefixes[prefix] == nil {
+			trackingTable.prefixes[prefix] = make(map[int64]bool)
+		}
+		trackingTable.prefixes[prefix][client.ID] = true
+	}
+	trackingTable.Unlock()
+	return "+OK\r\n"
+}
+
+// 处理 CLIENT CACHING YES|NO，只影响下一条命令
+func handleClientCaching(client *Client, args []string) string {
+	if len(args) != 1 {
+		return "-ERR wrong number of arguments for 'client|caching' command\r\n"
+	}
+	client.Lock()
+	defer client.Unlock()
+	state := &client.tracking
+	if !state.enabled || !(state.optin || state.optout) {
+		return "-ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled\r\n"
+	}
+	switch strings.ToUpper(args[0]) {
+	case "YES":
+		if !state.optin {
+			return "-ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.\r\n"
+		}
+		state.caching = "yes"
+	case "NO":
+		if !state.optout {
+			return "-ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.\r\n"
+		}
+		state.caching = "no"
+	default:
+		return "-ERR syntax error\r\n"
+	}
+	return "+OK\r\n"
+}
+
+// CLIENT GETREDIR：未开启 tracking 返回 -1
+func trackingRedirection(client *Client) int64 {
+	client.Lock()
+	defer client.Unlock()
+	if !client.tracking.enabled {
+		return -1
+	}
+	return client.tracking.redirect
+}
+
+// 处理 CLIENT TRACKINGINFO
+func trackingInfo(client *Client) string {
+	client.Lock()
+	state := client.tracking
+	client.Unlock()
+
+	var flags []string
+	redirect := int64(-1)
+	if !state.enabled {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		redirect = state.redirect
+		if state.bcast {
+			flags = append(flags, "bcast")
+		}
+		if state.optin {
+			flags = append(flags, "optin")
+			if state.caching == "yes" {
+				flags = append(flags, "caching-yes")
+			}
+		}
+		if state.optout {
+			flags = append(flags, "optout")
+			if state.caching == "no" {
+				flags = append(flags, "caching-no")
+			}
+		}
+		if redirect != 0 {
+			if _, exists := lookupClient(redirect); !exists {
+				flags = append(flags, "broken_redirect")
+			}
+		}
+	}
+
+	header := "*6\r\n"
+	if client.respVersion() == 3 {
+		header = "%3\r\n"
+	}
+	return header +
+		encodeBulkString("flags") + encodeArray(flags) +
+		encodeBulkString("redirect") + fmt.Sprintf(":%d\r\n", redirect) +
+		encodeBulkString("prefixes") + encodeArray(state.prefixes)
+}
+
+// 关闭 tracking，并从广播前缀表中移除
+func disableTracking(client *Client) {
+	client.Lock()
+	prefixes := client.tracking.prefixes
+	client.tracking = trackingState{}
+	client.Unlock()
+
+	trackingTable.Lock()
+	for _, prefix := range prefixes {
+		delete(trackingTable.prefixes[prefix], client.ID)
+		if len(trackingTable.prefixes[prefix]) == 0 {
+			delete(trackingTable.prefixes, prefix)
+		}
+	}
+	trackingTable.Unlock()
+	// 默认模式下记录的 key 不在这里清理，发送失效消息时会跳过未开启 tracking 的客户端
+}
+
+// 执行读命令前，记录客户端读取的 key（默认模式）
+// 先登记再读取，保证读取之后发生的修改一定会发出失效消息
+func trackReadKeys(client *Client, cmd string, args []string) {
+	client.Lock()
+	state := &client.tracking
+	caching := state.caching
+	if cmd != "CLIENT" {
+		state.caching = "" // CLIENT CACHING 只对紧接着的一条命令有效
+	}
+	record := state.enabled && !state.bcast
+	if state.optin && caching != "yes" {
+		record = false
+	}
+	if state.optout && caching == "no" {
+		record = false
+	}
+	client.Unlock()
+
+	if !record {
+		return
+	}
+	keys := readCommandKeys(cmd, args)
+	if len(keys) == 0 {
+		return
+	}
+	trackingTable.Lock()
+	for _, key := range keys {
+		if trackingTable.keys[key] == nil {
+			trackingTable.keys[key] = make(map[int64]bool)
+		}
+		trackingTable.keys[key][client.ID] = true
+	}
+	trackingTable.Unlock()
+}
+
+// 只读命令读取的 key
+func readCommandKeys(cmd string, args []string) []string {
+	switch cmd {
+	case "GET", "TYPE", "XRANGE":
+		if len(args) > 0 {
+			return args[:1]
+		}
+	case "XREAD":
+		for i, arg := range args {
+			if strings.ToLower(arg) == "streams" {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+	}
+	return nil
+}
+
+// key 被修改时通知缓存了它的客户端
+// 调用时不能持有 store 的锁
+func trackingInvalidateKey(key string) {
+	trackingTable.Lock()
+	targets := trackingTable.keys[key]
+	delete(trackingTable.keys, key) // 默认模式下通知一次后需要客户端重新读取才会再次记录
+	if len(trackingTable.prefixes) > 0 {
+		merged := make(map[int64]bool, len(targets))
+		for id := range targets {
+			merged[id] = true
+		}
+		for prefix, ids := range trackingTable.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				for id := range ids {
+					merged[id] = true
+				}
+			}
+		}
+		targets = merged
+	}
+	trackingTable.Unlock()
+
+	for id := range targets {
+		if client, exists := lookupClient(id); exists {
+			sendInvalidation(client, []string{key})
+		}
+	}
+}
+
+// 发送失效消息：RESP3 使用 push 消息，RESP2 通过 __redis__:invalidate 频道
+func sendInvalidation(client *Client, keys []string) {
+	client.Lock()
+	enabled, redirect := client.tracking.enabled, client.tracking.redirect
+	client.Unlock()
+	if !enabled {
+		return
+	}
+
+	target := client
+	if redirect != 0 {
+		var exists bool
+		target, exists = lookupClient(redirect)
+		if !exists {
+			if client.respVersion() == 3 {
+				client.Write([]byte(">2\r\n" + encodeBulkString("tracking-redir-broken") + fmt.Sprintf(":%d\r\n", redirect)))
+			}
+			return
+		}
+	}
+
+	payload := encodeArray(keys)
+	if target.respVersion() == 3 {
+		target.Write([]byte(">2\r\n" + encodeBulkString("invalidate") + payload))
+	} else if target.isSubscribed(invalidateChannel) {
+		target.sendMessage(invalidateChannel, payload)
+	}
+}
@@ -3,71 +3,87 @@ package main
 import (
 	"fmt"
 	"strings"
-    "net"
 )
 
 
-// 启动事务，清空队列并设置 inTransaction 标志
-func (server *ServerConfig) StartTransaction(conn net.Conn) {
-	server.Lock()
-	defer server.Unlock()
+// 事务状态属于连接，只在处理这个连接命令的协程（或执行协程）中访问，不需要加锁
 
-	server.inTransaction = true
-	server.transactionQueue = []string{} // 清空之前的队列
-	conn.Write([]byte("+OK\r\n"))
+// 启动事务，清空队列并设置 inTransaction 标志
+func (conn *Client) StartTransaction() {
+	conn.inTransaction = true
+	conn.transactionQueue = [][]string{} // 清空之前的队列
+	conn.queueReply([]byte("+OK\r\n"))
 }
 
 // 执行事务中的所有命令
-func (server *ServerConfig) ExecuteTransaction(conn net.Conn) {
-	server.Lock()
-	defer server.Unlock()
-
-	if !server.inTransaction {
-		conn.Write([]byte("-ERR NOT in MULTI mode\r\n"))
+func (conn *Client) ExecuteTransaction() {
+	if !conn.inTransaction {
+		conn.queueReply([]byte("-ERR NOT in MULTI mode\r\n"))
 		return
 	}
 
 	// 先构造 RESP 数组的头部
 	responseLines := []string{}
-	responseLines = append(responseLines, fmt.Sprintf("*%d", len(server.transactionQueue)))
+	responseLines = append(responseLines, fmt.Sprintf("*%d", len(conn.transactionQueue)))
+	for _, response := range execCommands(conn.transactionQueue) {
+		responseLines = append(responseLines, strings.TrimSpace(response)) // 可能需要 trim 掉 \r\n，避免重复
+	}
 
-	// 执行所有排队的命令
-	for _, queuedCmd := range server.transactionQueue {
-		fmt.Println("Executing queued command:", queuedCmd)
+	// 事务结束，清空队列并退出事务模式
+	conn.transactionQueue = [][]string{}
+	conn.inTransaction = false
 
-		// 解析命令
-		cmdArgs := strings.Split(queuedCmd, " ")
-		cmd := cmdArgs[0]
-		args := cmdArgs[1:]
+	// 组装 RESP 返回给客户端
+	finalResponse := strings.Join(responseLines, "\r\n") + "\r\n"
+	conn.queueReply([]byte(finalResponse))
+}
+
+// 作为一个整体执行一组命令，返回每条命令的响应；EXEC 和 slave 执行 master 传播的事务时使用
+// 成功的写命令作为一个整体传播（MULTI/EXEC 包裹）
+func execCommands(commands [][]string) []string {
+	// 按分片编号顺序独占事务涉及的所有分片，其他命令不会穿插在事务的命令之间
+	// 和 callCommand 一样先拿分片的事务锁再拿 writeGate，顺序一致才不会死锁
+	var keys []string
+	for _, argv := range commands {
+		keys = append(keys, commandKeys(argv[0], argv[1:])...)
+	}
+	unlockGates := lockShardGates(keys)
+	defer unlockGates()
+
+	// 执行所有排队的命令，整个事务期间不允许 AOF 重写切换文件
+	writeGate.RLock()
+	defer writeGate.RUnlock()
+	var responses []string
+	writeCommands := expireKeys(keys) // 成功执行的写命令（和之前删除过期 key 的 DEL），作为一个整体传播
+	for _, argv := range commands {
+		fmt.Println("Executing queued command:", argv)
+		cmd, args := argv[0], argv[1:]
 
 		handler, exists := commandHandlers[cmd]
 		if !exists {
-			responseLines = append(responseLines, "-ERR unknown command")
+			responses = append(responses, "-ERR unknown command\r\n")
 			continue
 		}
 
-		// 执行命令并获取 RESP 响应
+		// 执行命令并获取 RESP 响应，XREAD BLOCK 在事务中不阻塞
+		if cmd == "XREAD" {
+			handler = nonBlockingXREAD
+		}
 		response := handler(args)
-		responseLines = append(responseLines, strings.TrimSpace(response)) // 可能需要 trim 掉 \r\n，避免重复
+		if shouldPropagate(cmd, response) {
+			writeCommands = append(writeCommands, deterministicCommand(cmd, args, response))
+		}
+		responses = append(responses, response)
 	}
 
-	// 事务结束，清空队列并退出事务模式
-	server.transactionQueue = []string{}
-	server.inTransaction = false
-
-	// 组装 RESP 返回给客户端
-	finalResponse := strings.Join(responseLines, "\r\n") + "\r\n"
-	conn.Write([]byte(finalResponse))
+	propagateWrites(writeCommands)
+	return responses
 }
 
 // 在事务模式下将命令排队
-func (server *ServerConfig) QueueTransactionCommand(cmd string, args []string, conn net.Conn) {
-	server.Lock()
-	defer server.Unlock()
-
-	if server.inTransaction {
-		cmdLine := fmt.Sprintf("%s %s", cmd, strings.Join(args, " "))
-		server.transactionQueue = append(server.transactionQueue, cmdLine)
-		conn.Write([]byte("+QUEUED\r\n"))
+func (conn *Client) QueueTransactionCommand(cmd string, args []string) {
+	if conn.inTransaction {
+		conn.transactionQueue = append(conn.transactionQueue, append([]string{cmd}, args...))
+		conn.queueReply([]byte("+QUEUED\r\n"))
 	}
-}
\ No newline at end of file
+}
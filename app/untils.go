@@ -1,115 +1,123 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
-	"errors"
-	"os"
 	"fmt"
 	"strings"
-	"net"
-	// "strconv"
+	"strconv"
 	// "sync"
 	// "time"
 )
 	
-func writeString(buf *bytes.Buffer, str string) {
-	// 计算字符串长度
-	strLen := byte(len(str))
-	// 写入字符串长度和字符串内容
-	buf.Write([]byte{strLen})
-	buf.Write([]byte(str))
-}
-
-func writeLengthEncodedInt(buf *bytes.Buffer, value int) {
-	// 将整数按照长度编码规则写入文件
-	// 此处假设值小于 64, 使用简单的字节表示
-	buf.Write([]byte{byte(value)})
-}
-
-func writeUint32(buf *bytes.Buffer, value uint32) {
-	// 写入 4 字节无符号整数
-	bufBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bufBytes, value)
-	buf.Write(bufBytes)
-}
-
-
-// 读取大小编码的数值
-func readSizeEncoded(file *os.File) (uint32, error) {
-	var sizeByte byte
-	err := binary.Read(file, binary.LittleEndian, &sizeByte)
-	if err != nil {
-		return 0, err
-	}
-
-	
-
-	// 小于 64，直接使用一个字节
-	if sizeByte&0xC0 == 0x00 {
-		return uint32(sizeByte & 0x3F), nil
-	}
-
-	// 大于等于 64，使用两个字节
-	if sizeByte&0xC0 == 0x40 {
-		var nextByte byte
-		err = binary.Read(file, binary.LittleEndian, &nextByte)
-		if err != nil {
-			return 0, err
+// 解析内存大小配置，支持 1024、64kb、100mb、1gb 这样的写法
+func parseMemorySize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		factor int64
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000}, {"b", 1}}
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			factor = unit.factor
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
 		}
-		// 调试输出，查看第二个字节
-		fmt.Printf("Read next byte: 0x%X\n", nextByte)
-
-		return uint32((uint32(sizeByte&0x3F) << 8) | uint32(nextByte)), nil
 	}
-
-	// 不支持的编码类型
-	return 0, errors.New("unsupported size encoding")
-}
-
-// 读取字符串（4byte）
-func readString(file *os.File) (string, error) {
-	var keySize byte
-	err := binary.Read(file, binary.LittleEndian, &keySize)
-	if err != nil {
-		return "", err
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size '%s'", s)
 	}
-
-	key := make([]byte, keySize)
-	_, err = file.Read(key)
-	if err != nil {
-		return "", err
-	}
-
-	return string(key), nil
+	return n * factor, nil
 }
 
-// 判断是否是写命令
-func isReadCommand(cmd string) bool {
-    ReadCommands := []string{"GET", "ECHO", "KEYS","REPLCONF"}
-    for _, rcmd := range ReadCommands {
-        if strings.HasPrefix(strings.ToUpper(cmd), rcmd) {
-            return true
-        }
-    }
-    return false
+// 编码 RESP 批量字符串
+func encodeBulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
 }
 
-// 增加 offset ，保证原子性
-func (s *ServerConfig) IncrementOffset(n int64) {
-	s.Lock()
-	s.ReplOffset += n
-	s.Unlock()
-	// s.BroadcastToReplicas()
+// 编码由批量字符串组成的 RESP 数组
+func encodeArray(items []string) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("*%d\r\n", len(items)))
+	for _, item := range items {
+		buf.WriteString(encodeBulkString(item))
+	}
+	return buf.String()
 }
 
-// 添加副本连接，保证原子性
-func (s *ServerConfig) AddReplicaConnection(conn net.Conn) {
-	s.Lock()
-	defer s.Unlock()
-	s.replicaConnections = append(s.replicaConnections, conn)
+// 按 Redis 的 glob 规则匹配（stringmatchlen）：* 匹配任意字符串（包括 /），? 匹配一个字符，
+// [abc]、[^a]、[a-z] 匹配字符集合，\ 转义下一个字符；按字节比较，和 Redis 一样区分大小写
+func stringMatch(pattern, str string) bool {
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for i := s; i <= len(str); i++ {
+				if stringMatch(pattern[p+1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for p < len(pattern) && pattern[p] != ']' {
+				switch {
+				case pattern[p] == '\\' && p+1 < len(pattern):
+					p++
+					if pattern[p] == str[s] {
+						match = true
+					}
+				case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[s] >= start && str[s] <= end {
+						match = true
+					}
+					p += 2
+				default:
+					if pattern[p] == str[s] {
+						match = true
+					}
+				}
+				p++
+			}
+			if match == not {
+				return false
+			}
+			s++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if s >= len(str) || pattern[p] != str[s] {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+	return s == len(str)
 }
-
-
-
-
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 复制确认：slave 每秒发送 REPLCONF ACK <offset> FACK <aofoffset>，master 收到 GETACK 时立即发送
+// WAIT、WAITAOF 阻塞客户端，直到足够多的 slave 确认了调用时的复制偏移量
+
+// slave 发给 master 的确认：已经处理的复制偏移量和已经 fsync 到 AOF 的复制偏移量
+func replconfAck() string {
+	offset := strconv.FormatInt(currentReplOffset(), 10)
+	aofOffset := strconv.FormatInt(aofFsyncedOffset(), 10)
+	return encodeArray([]string{"REPLCONF", "ACK", offset, "FACK", aofOffset})
+}
+
+// slave 每秒向 master 发送一次确认，直到 done 关闭
+func sendAcksToMaster(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := conn.Write([]byte(replconfAck())); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// master 处理 slave 发来的 REPLCONF ACK <offset> [FACK <aofoffset>]，不回复
+func handleReplconfAck(conn *Client, args []string) {
+	offset, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return
+	}
+	aofOffset := int64(-1)
+	if len(args) >= 3 && strings.ToUpper(args[1]) == "FACK" {
+		if aofOffset, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return
+		}
+	}
+
+	config.Lock()
+	if offset > conn.repl.ackOffset {
+		conn.repl.ackOffset = offset
+	}
+	conn.repl.ackAOFOffset = aofOffset
+	conn.repl.ackTime = time.Now()
+	config.Unlock()
+	notifyReplAck()
+}
+
+// 唤醒等待中的 WAIT、WAITAOF，重新检查确认的偏移量
+func notifyReplAck() {
+	config.Lock()
+	close(config.ackNotify)
+	config.ackNotify = make(chan struct{})
+	config.Unlock()
+}
+
+// 让所有 slave 立即发送确认，REPLCONF GETACK * 作为复制流的一部分发送
+func requestReplicaAcks() {
+	propagateToSlaves(encodeArray([]string{"REPLCONF", "GETACK", "*"}))
+}
+
+// 由 serverCron 定期调用：有客户端阻塞在 WAIT 中时，向 slave 请求确认
+func replicationCron() {
+	config.Lock()
+	waiting := config.waitingClients > 0
+	config.Unlock()
+	if waiting {
+		requestReplicaAcks()
+	}
+}
+
+// 等待 numreplicas 个 slave 确认当前的复制偏移量（aof 为 true 时要求已经 fsync 到 AOF），
+// numlocal 为 1 时还要等待本地 AOF fsync；timeout 为 0 表示一直等待
+// 返回本地是否已经 fsync（0 或 1）和确认的 slave 数
+func waitForOffset(numlocal, numreplicas int, timeout time.Duration, aof bool) (int, int) {
+	target := currentReplOffset()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	registered := false
+	defer func() {
+		if registered {
+			config.Lock()
+			config.waitingClients--
+			config.Unlock()
+		}
+	}()
+
+	for {
+		local := 0
+		if numlocal > 0 && aofFsyncedOffset() >= target {
+			local = 1
+		}
+
+		config.Lock()
+		acked := 0
+		for _, replica := range config.replicas {
+			// 还在全量同步的 slave 没有数据，确认偏移量是初始值 0，不能计入
+			if replica.repl.state != "online" {
+				continue
+			}
+			ackOffset := replica.repl.ackOffset
+			if aof {
+				ackOffset = replica.repl.ackAOFOffset
+			}
+			if ackOffset >= target {
+				acked++
+			}
+		}
+		notify := config.ackNotify
+		satisfied := local >= numlocal && acked >= numreplicas
+		first := !satisfied && !registered
+		if first {
+			config.waitingClients++
+			registered = true
+		}
+		config.Unlock()
+
+		if satisfied {
+			return local, acked
+		}
+		if first {
+			requestReplicaAcks() // 之后由 replicationCron 定期请求
+		}
+		select {
+		case <-notify:
+		case <-deadline:
+			return local, acked
+		}
+	}
+}
+
+// 解析 WAIT、WAITAOF 的超时时间（毫秒）
+func parseWaitTimeout(arg string) (time.Duration, string) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, "-ERR timeout is not an integer or out of range\r\n"
+	}
+	if ms < 0 {
+		return 0, "-ERR timeout is negative\r\n"
+	}
+	return time.Duration(ms) * time.Millisecond, ""
+}
+
+// 处理 WAIT numreplicas timeout：阻塞到 numreplicas 个 slave 确认了之前的写命令，或者超时，返回确认的 slave 数
+func handleWAIT(args []string) string {
+	if len(args) != 2 {
+		return "-ERR wrong number of arguments for 'wait' command\r\n"
+	}
+	if getRole() != "master" {
+		return "-ERR WAIT cannot be used with replica instances.\r\n"
+	}
+	numreplicas, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "-ERR value is not an integer or out of range\r\n"
+	}
+	timeout, reply := parseWaitTimeout(args[1])
+	if reply != "" {
+		return reply
+	}
+	_, acked := waitForOffset(0, numreplicas, timeout, false)
+	return fmt.Sprintf(":%d\r\n", acked)
+}
+
+// 处理 WAITAOF numlocal numreplicas timeout：阻塞到本地（numlocal 为 1 时）和 numreplicas 个 slave
+// 把之前的写命令 fsync 到 AOF，或者超时，返回 [本地是否已经 fsync, fsync 的 slave 数]
+func handleWAITAOF(args []string) string {
+	if len(args) != 3 {
+		return "-ERR wrong number of arguments for 'waitaof' command\r\n"
+	}
+	if getRole() != "master" {
+		return "-ERR WAITAOF cannot be used with replica instances.\r\n"
+	}
+	numlocal, err := strconv.Atoi(args[0])
+	if err != nil || numlocal < 0 {
+		return "-ERR value is out of range, must be positive\r\n"
+	}
+	numreplicas, err := strconv.Atoi(args[1])
+	if err != nil || numreplicas < 0 {
+		return "-ERR value is out of range, must be positive\r\n"
+	}
+	timeout, reply := parseWaitTimeout(args[2])
+	if reply != "" {
+		return reply
+	}
+	if numlocal > 0 && !aofEnabled() {
+		return "-ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.\r\n"
+	}
+	local, acked := waitForOffset(min(numlocal, 1), numreplicas, timeout, true)
+	return fmt.Sprintf("*2\r\n:%d\r\n:%d\r\n", local, acked)
+}
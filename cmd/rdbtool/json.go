@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/rdb"
+)
+
+// JSON 中的一个 key，json 命令输出和 convert -from json 输入用同一种格式
+type jsonEntry struct {
+	DB       uint64          `json:"db"`
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	ExpireAt int64           `json:"expire_at,omitempty"` // 毫秒时间戳
+	Value    json.RawMessage `json:"value"`
+}
+
+// 有序集合的成员；分数为 inf、-inf、nan 时以字符串表示
+type jsonZsetMember struct {
+	Member string          `json:"member"`
+	Score  json.RawMessage `json:"score"`
+}
+
+// 流的值
+type jsonStream struct {
+	Entries []rdb.StreamEntry   `json:"entries"`
+	Groups  []rdb.ConsumerGroup `json:"groups"`
+}
+
+// json：输出一个 JSON 数组，每个 key 一行
+func runJSON(args []string) error {
+	fs := flag.NewFlagSet("json", flag.ExitOnError)
+	pattern := fs.String("pattern", "", "only keys matching this glob pattern")
+	path := parseArgs(fs, args)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	out.WriteString("[")
+	first := true
+	_, err := readEntries(path, *pattern, func(entry *rdb.Entry) error {
+		value, err := encodeJSONValue(entry.Value)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", entry.Key, err)
+		}
+		line, err := json.Marshal(jsonEntry{
+			DB:       entry.DB,
+			Key:      entry.Key,
+			Type:     rdb.TypeName(entry.Value),
+			ExpireAt: entry.ExpireAt,
+			Value:    value,
+		})
+		if err != nil {
+			return err
+		}
+		if !first {
+			out.WriteString(",")
+		}
+		first = false
+		out.WriteString("\n")
+		out.Write(line)
+		return nil
+	})
+	out.WriteString("\n]\n")
+	return err
+}
+
+func encodeJSONValue(value interface{}) (json.RawMessage, error) {
+	switch v := value.(type) {
+	case nil:
+		return json.RawMessage("null"), nil
+	case map[string]bool:
+		members := make([]string, 0, len(v))
+		for member := range v {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return json.Marshal(members)
+	case map[string]float64:
+		members := make([]jsonZsetMember, 0, len(v))
+		for member, score := range v {
+			members = append(members, jsonZsetMember{Member: member, Score: encodeScore(score)})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if v[members[i].Member] != v[members[j].Member] {
+				return v[members[i].Member] < v[members[j].Member]
+			}
+			return members[i].Member < members[j].Member
+		})
+		return json.Marshal(members)
+	case *rdb.Stream:
+		return json.Marshal(jsonStream{Entries: v.Entries, Groups: v.Groups})
+	}
+	return json.Marshal(value)
+}
+
+func encodeScore(score float64) json.RawMessage {
+	switch {
+	case math.IsNaN(score):
+		return json.RawMessage(`"nan"`)
+	case math.IsInf(score, 1):
+		return json.RawMessage(`"inf"`)
+	case math.IsInf(score, -1):
+		return json.RawMessage(`"-inf"`)
+	}
+	return json.RawMessage(strconv.FormatFloat(score, 'g', -1, 64))
+}
+
+func decodeScore(raw json.RawMessage) (float64, error) {
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return strconv.ParseFloat(str, 64) // 能解析 inf、-inf、nan
+	}
+	var score float64
+	err := json.Unmarshal(raw, &score)
+	return score, err
+}
+
+// 读取 json 命令输出格式的文件
+func readJSON(r io.Reader) (dataset, error) {
+	var entries []jsonEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	data := make(dataset)
+	for _, entry := range entries {
+		value, err := decodeJSONValue(entry.Type, entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", entry.Key, err)
+		}
+		if value == nil {
+			fmt.Fprintf(os.Stderr, "skipping %s key %q\n", entry.Type, entry.Key)
+			continue
+		}
+		data.db(entry.DB)[entry.Key] = &item{value: value, expireAt: entry.ExpireAt}
+	}
+	return data, nil
+}
+
+func decodeJSONValue(typeName string, raw json.RawMessage) (interface{}, error) {
+	switch typeName {
+	case "string":
+		var str string
+		err := json.Unmarshal(raw, &str)
+		return str, err
+	case "list":
+		var list []string
+		err := json.Unmarshal(raw, &list)
+		return list, err
+	case "set":
+		var members []string
+		if err := json.Unmarshal(raw, &members); err != nil {
+			return nil, err
+		}
+		set := make(map[string]bool, len(members))
+		for _, member := range members {
+			set[member] = true
+		}
+		return set, nil
+	case "hash":
+		var hash map[string]string
+		err := json.Unmarshal(raw, &hash)
+		return hash, err
+	case "zset":
+		var members []jsonZsetMember
+		if err := json.Unmarshal(raw, &members); err != nil {
+			return nil, err
+		}
+		zset := make(map[string]float64, len(members))
+		for _, member := range members {
+			score, err := decodeScore(member.Score)
+			if err != nil {
+				return nil, fmt.Errorf("member %q: invalid score", member.Member)
+			}
+			zset[member.Member] = score
+		}
+		return zset, nil
+	case "stream":
+		var stream jsonStream
+		if err := json.Unmarshal(raw, &stream); err != nil {
+			return nil, err
+		}
+		return &rdb.Stream{Entries: stream.Entries, Groups: stream.Groups}, nil
+	case "module":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", typeName)
+}
@@ -0,0 +1,310 @@
+// rdbtool 离线检查和转换 RDB 文件，和服务端共用 internal/rdb 的读写代码
+//
+//	rdbtool check <dump.rdb>                           校验文件格式和 CRC64 校验和
+//	rdbtool json [-pattern p] <dump.rdb>               以 JSON 输出所有 key
+//	rdbtool stats [-pattern p] [-top n] <dump.rdb>     按 key 和类型统计占用的字节数
+//	rdbtool convert [-from json|resp] -o <out.rdb> <input>
+//	                                                   把 JSON（json 命令的输出格式）或 RESP 命令流（如 AOF）转换成 RDB
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/rdb"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "check":
+		err = runCheck(os.Args[2:])
+	case "json":
+		err = runJSON(os.Args[2:])
+	case "stats":
+		err = runStats(os.Args[2:])
+	case "convert":
+		err = runConvert(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rdbtool:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  rdbtool check <dump.rdb>
+  rdbtool json [-pattern p] <dump.rdb>
+  rdbtool stats [-pattern p] [-top n] <dump.rdb>
+  rdbtool convert [-from json|resp] -o <out.rdb> <input>`)
+	os.Exit(2)
+}
+
+// 解析子命令的参数，要求正好一个文件参数
+func parseArgs(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0)
+}
+
+// 依次读取文件中匹配 pattern 的 key，pattern 为空时全部返回
+func readEntries(path, pattern string, fn func(entry *rdb.Entry) error) (*rdb.Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := rdb.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return reader, nil
+		}
+		if err != nil {
+			return reader, fmt.Errorf("at offset %d: %w", reader.Offset(), err)
+		}
+		if pattern != "" {
+			if matched, _ := filepath.Match(pattern, entry.Key); !matched {
+				continue
+			}
+		}
+		if err := fn(entry); err != nil {
+			return reader, err
+		}
+	}
+}
+
+// check：完整读一遍文件，校验和不一致或格式错误时返回错误
+func runCheck(args []string) error {
+	path := parseArgs(flag.NewFlagSet("check", flag.ExitOnError), args)
+	keys := 0
+	dbs := make(map[uint64]bool)
+	reader, err := readEntries(path, "", func(entry *rdb.Entry) error {
+		keys++
+		dbs[entry.DB] = true
+		return nil
+	})
+	if errors.Is(err, rdb.ErrChecksum) {
+		return fmt.Errorf("%s: checksum mismatch: %v", path, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	fmt.Printf("RDB version %d, redis-ver %s\n", reader.Version, reader.Aux["redis-ver"])
+	fmt.Printf("%d keys in %d databases, %d bytes\n", keys, len(dbs), reader.Offset())
+	fmt.Println("RDB looks OK")
+	return nil
+}
+
+// 一个 key 的统计
+type keyStats struct {
+	db       uint64
+	key      string
+	typeName string
+	size     int64 // 在 RDB 文件中占用的字节数
+	elements int   // 元素个数，字符串为 1
+	expireAt int64
+}
+
+// stats：每个 key 的类型、元素数和字节数（按字节数从大到小），以及按类型的汇总
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	pattern := fs.String("pattern", "", "only keys matching this glob pattern")
+	top := fs.Int("top", 0, "only print the n largest keys (0 prints all)")
+	path := parseArgs(fs, args)
+
+	var all []keyStats
+	_, err := readEntries(path, *pattern, func(entry *rdb.Entry) error {
+		all = append(all, keyStats{
+			db:       entry.DB,
+			key:      entry.Key,
+			typeName: rdb.TypeName(entry.Value),
+			size:     entry.Size,
+			elements: elementCount(entry.Value),
+			expireAt: entry.ExpireAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].size > all[j].size })
+	listed := all
+	if *top > 0 && len(listed) > *top {
+		listed = listed[:*top]
+	}
+	fmt.Printf("%-4s %-8s %10s %10s %15s  %s\n", "db", "type", "bytes", "elements", "expire_at", "key")
+	for _, ks := range listed {
+		fmt.Printf("%-4d %-8s %10d %10d %15d  %q\n", ks.db, ks.typeName, ks.size, ks.elements, ks.expireAt, ks.key)
+	}
+
+	type typeStats struct {
+		keys     int
+		size     int64
+		elements int
+	}
+	byType := make(map[string]*typeStats)
+	var typeNames []string
+	var total int64
+	for _, ks := range all {
+		stats, ok := byType[ks.typeName]
+		if !ok {
+			stats = &typeStats{}
+			byType[ks.typeName] = stats
+			typeNames = append(typeNames, ks.typeName)
+		}
+		stats.keys++
+		stats.size += ks.size
+		stats.elements += ks.elements
+		total += ks.size
+	}
+	sort.Strings(typeNames)
+	fmt.Printf("\n%-8s %10s %12s %12s\n", "type", "keys", "bytes", "elements")
+	for _, name := range typeNames {
+		stats := byType[name]
+		fmt.Printf("%-8s %10d %12d %12d\n", name, stats.keys, stats.size, stats.elements)
+	}
+	fmt.Printf("%-8s %10d %12d\n", "total", len(all), total)
+	return nil
+}
+
+// 值的元素个数：列表、集合、哈希、有序集合的成员数，流的条目数
+func elementCount(value interface{}) int {
+	switch v := value.(type) {
+	case []string:
+		return len(v)
+	case map[string]bool:
+		return len(v)
+	case map[string]string:
+		return len(v)
+	case map[string]float64:
+		return len(v)
+	case *rdb.Stream:
+		return len(v.Entries)
+	case string:
+		return 1
+	}
+	return 0
+}
+
+// convert：读取 JSON 或 RESP 输入，按 db、key 排序后写成 RDB
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "json", "input format: json or resp")
+	output := fs.String("o", "", "output RDB file")
+	path := parseArgs(fs, args)
+	if *output == "" {
+		return errors.New("convert: -o is required")
+	}
+
+	input, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	var data dataset
+	switch *from {
+	case "json":
+		data, err = readJSON(input)
+	case "resp":
+		data, err = readRESP(input)
+	default:
+		return fmt.Errorf("convert: unknown input format %q", *from)
+	}
+	if err != nil {
+		return err
+	}
+	return writeDataset(*output, data)
+}
+
+// 转换过程中的数据：db -> key -> 值
+type dataset map[uint64]map[string]*item
+
+type item struct {
+	value    interface{}
+	expireAt int64
+}
+
+func (d dataset) db(n uint64) map[string]*item {
+	if d[n] == nil {
+		d[n] = make(map[string]*item)
+	}
+	return d[n]
+}
+
+// 写入 RDB：先写临时文件，成功后再 rename，避免留下半个文件
+func writeDataset(path string, data dataset) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	writer := rdb.NewWriter(file)
+	writer.WriteHeader()
+	writer.WriteAux("redis-ver", "7.2.0")
+	writer.WriteAux("redis-bits", "64")
+
+	dbs := make([]uint64, 0, len(data))
+	for db := range data {
+		dbs = append(dbs, db)
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i] < dbs[j] })
+	keys := 0
+	for _, db := range dbs {
+		items := data[db]
+		names := make([]string, 0, len(items))
+		expires := 0
+		for key, it := range items {
+			names = append(names, key)
+			if it.expireAt != 0 {
+				expires++
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+		writer.SelectDB(db, len(names), expires)
+		for _, key := range names {
+			if err := writer.WriteEntry(key, items[key].value, items[key].expireAt); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		keys += len(names)
+	}
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d keys to %s\n", keys, path)
+	return nil
+}
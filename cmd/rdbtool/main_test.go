@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/rdb"
+)
+
+// 生成一个包含各种类型的合法 RDB 文件
+func validRDB(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer := rdb.NewWriter(&buf)
+	writer.WriteHeader()
+	writer.WriteAux("redis-ver", "7.2.0")
+	writer.SelectDB(0, 6, 1)
+	writer.WriteEntry("str", "value", 0)
+	writer.WriteEntry("big", strings.Repeat("abcdefgh", 200), 4102444800000) // 长字符串使用 LZF 压缩
+	writer.WriteEntry("list", []string{"a", "b", "c"}, 0)
+	writer.WriteEntry("set", map[string]bool{"x": true, "y": true}, 0)
+	writer.WriteEntry("hash", map[string]string{"f": "v"}, 0)
+	writer.WriteEntry("stream", &rdb.Stream{
+		Entries: []rdb.StreamEntry{{ID: "1-1", Fields: map[string]string{"f": "v"}}},
+		Groups: []rdb.ConsumerGroup{{
+			Name: "g", LastID: "1-1", EntriesRead: 1,
+			Pending:   []rdb.PendingEntry{{ID: "1-1", Consumer: "c", DeliveryTime: 1, DeliveryCount: 1}},
+			Consumers: []rdb.Consumer{{Name: "c", SeenTime: 1, ActiveTime: 1}},
+		}},
+	}, 0)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 把数据写到临时文件后执行 check
+func checkData(t *testing.T, data []byte) error {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return runCheck([]string{path})
+}
+
+func TestCheckValid(t *testing.T) {
+	if err := checkData(t, validRDB(t)); err != nil {
+		t.Fatalf("check failed on a valid file: %v", err)
+	}
+}
+
+func TestCheckTruncated(t *testing.T) {
+	data := validRDB(t)
+	for n := 0; n < len(data); n++ {
+		if err := checkData(t, data[:n]); err == nil {
+			t.Fatalf("file truncated to %d of %d bytes passed check", n, len(data))
+		}
+	}
+}
+
+func TestCheckBadChecksum(t *testing.T) {
+	data := validRDB(t)
+	data[len(data)-1] ^= 0xFF
+	err := checkData(t, data)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+// 改动任意一个字节都应该报错（格式错误或者校验和不一致），不能崩溃
+func TestCheckCorruptedByte(t *testing.T) {
+	data := validRDB(t)
+	for i := range data {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0xFF
+		if err := checkData(t, corrupted); err == nil {
+			t.Fatalf("file with byte %d corrupted passed check", i)
+		}
+	}
+}
+
+func TestCheckOversizedLength(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"64-bit key length", "REDIS0011\x00\x81\x7f\xff\xff\xff\xff\xff\xff\xff", "exceeds the limit"},
+		{"32-bit key length", "REDIS0011\x00\x80\x7f\xff\xff\xff", "exceeds the limit"},
+		{"64-bit length overflowing int", "REDIS0011\x00\x81\xff\xff\xff\xff\xff\xff\xff\xff", "exceeds the limit"},
+		{"lzf original length", "REDIS0011\x00\xc3\x01\x80\x7f\xff\xff\xffa", "exceeds the limit"},
+		{"value longer than the file", "REDIS0011\x00\x01k\x80\x10\x00\x00\x00abc", "unexpected EOF"},
+		{"huge list length", "REDIS0011\x01\x01k\x81\x7f\xff\xff\xff\xff\xff\xff\xff", "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkData(t, []byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/rdb"
+)
+
+// 读取 RESP 命令流（AOF 文件或 redis-cli --pipe 的输入），在内存中执行写命令
+func readRESP(r io.Reader) (dataset, error) {
+	reader := bufio.NewReader(r)
+	if header, err := reader.Peek(5); err == nil && string(header) == "REDIS" {
+		return nil, errors.New("input starts with an RDB preamble, use the RDB file directly")
+	}
+
+	data := make(dataset)
+	var db uint64
+	unsupported := make(map[string]int)
+	for {
+		args, err := readCommand(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(args[0])
+		if name == "SELECT" {
+			if len(args) != 2 {
+				return nil, errors.New("SELECT: wrong number of arguments")
+			}
+			if db, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("SELECT: invalid db %q", args[1])
+			}
+			continue
+		}
+		apply, ok := respCommands[name]
+		if !ok {
+			unsupported[name]++
+			continue
+		}
+		if err := apply(data.db(db), args[1:]); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	for name, count := range unsupported {
+		fmt.Fprintf(os.Stderr, "ignored %d %s commands\n", count, name)
+	}
+
+	// 去掉已过期的 key
+	now := time.Now().UnixMilli()
+	for _, items := range data {
+		for key, it := range items {
+			if it.expireAt != 0 && it.expireAt <= now {
+				delete(items, key)
+			}
+		}
+	}
+	return data, nil
+}
+
+// 读一条命令：RESP 数组或者按空白分隔的内联命令
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// 支持的写命令，参数不含命令名
+var respCommands = map[string]func(items map[string]*item, args []string) error{
+	"SET":       applySet,
+	"DEL":       applyDel,
+	"UNLINK":    applyDel,
+	"EXPIRE":    applyExpire(time.Second, false),
+	"PEXPIRE":   applyExpire(time.Millisecond, false),
+	"EXPIREAT":  applyExpire(time.Second, true),
+	"PEXPIREAT": applyExpire(time.Millisecond, true),
+	"PERSIST":   applyPersist,
+	"RPUSH":     applyPush(false),
+	"LPUSH":     applyPush(true),
+	"SADD":      applySadd,
+	"HSET":      applyHset,
+	"HMSET":     applyHset,
+	"ZADD":      applyZadd,
+	"XADD":      applyXadd,
+	"XGROUP":    applyXgroup,
+	"MULTI":     func(map[string]*item, []string) error { return nil },
+	"EXEC":      func(map[string]*item, []string) error { return nil },
+}
+
+// SET key value [NX|XX] [EX s|PX ms|EXAT s|PXAT ms|KEEPTTL]
+func applySet(items map[string]*item, args []string) error {
+	if len(args) < 2 {
+		return errors.New("wrong number of arguments")
+	}
+	var expireAt int64
+	keepTTL := false
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "NX":
+			if items[args[0]] != nil {
+				return nil
+			}
+		case "XX":
+			if items[args[0]] == nil {
+				return nil
+			}
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return errors.New("syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid expire time %q", args[i+1])
+			}
+			i++
+			switch option {
+			case "EX":
+				expireAt = time.Now().UnixMilli() + n*1000
+			case "PX":
+				expireAt = time.Now().UnixMilli() + n
+			case "EXAT":
+				expireAt = n * 1000
+			case "PXAT":
+				expireAt = n
+			}
+		default:
+			return fmt.Errorf("unsupported option %q", args[i])
+		}
+	}
+	if keepTTL && items[args[0]] != nil {
+		expireAt = items[args[0]].expireAt
+	}
+	items[args[0]] = &item{value: args[1], expireAt: expireAt}
+	return nil
+}
+
+func applyDel(items map[string]*item, args []string) error {
+	for _, key := range args {
+		delete(items, key)
+	}
+	return nil
+}
+
+func applyExpire(unit time.Duration, absolute bool) func(map[string]*item, []string) error {
+	return func(items map[string]*item, args []string) error {
+		if len(args) < 2 {
+			return errors.New("wrong number of arguments")
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid expire time %q", args[1])
+		}
+		it := items[args[0]]
+		if it == nil {
+			return nil
+		}
+		expireAt := n * int64(unit/time.Millisecond)
+		if !absolute {
+			expireAt += time.Now().UnixMilli()
+		}
+		it.expireAt = expireAt
+		return nil
+	}
+}
+
+func applyPersist(items map[string]*item, args []string) error {
+	if len(args) != 1 {
+		return errors.New("wrong number of arguments")
+	}
+	if it := items[args[0]]; it != nil {
+		it.expireAt = 0
+	}
+	return nil
+}
+
+// 取出 key 的值，不存在时用 create 新建；类型不对时报错
+func lookupValue[T any](items map[string]*item, key string, create func() T) (T, error) {
+	if it := items[key]; it != nil {
+		value, ok := it.value.(T)
+		if !ok {
+			var zero T
+			return zero, fmt.Errorf("key %q holds a %s", key, rdb.TypeName(it.value))
+		}
+		return value, nil
+	}
+	value := create()
+	items[key] = &item{value: value}
+	return value, nil
+}
+
+func applyPush(left bool) func(map[string]*item, []string) error {
+	return func(items map[string]*item, args []string) error {
+		if len(args) < 2 {
+			return errors.New("wrong number of arguments")
+		}
+		list, err := lookupValue(items, args[0], func() []string { return nil })
+		if err != nil {
+			return err
+		}
+		for _, element := range args[1:] {
+			if left {
+				list = append([]string{element}, list...)
+			} else {
+				list = append(list, element)
+			}
+		}
+		items[args[0]].value = list
+		return nil
+	}
+}
+
+func applySadd(items map[string]*item, args []string) error {
+	if len(args) < 2 {
+		return errors.New("wrong number of arguments")
+	}
+	set, err := lookupValue(items, args[0], func() map[string]bool { return make(map[string]bool) })
+	if err != nil {
+		return err
+	}
+	for _, member := range args[1:] {
+		set[member] = true
+	}
+	return nil
+}
+
+func applyHset(items map[string]*item, args []string) error {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return errors.New("wrong number of arguments")
+	}
+	hash, err := lookupValue(items, args[0], func() map[string]string { return make(map[string]string) })
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(args); i += 2 {
+		hash[args[i]] = args[i+1]
+	}
+	return nil
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] score member ...
+func applyZadd(items map[string]*item, args []string) error {
+	if len(args) < 3 {
+		return errors.New("wrong number of arguments")
+	}
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option != "NX" && option != "XX" && option != "GT" && option != "LT" && option != "CH" {
+			break
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errors.New("syntax error")
+	}
+	zset, err := lookupValue(items, args[0], func() map[string]float64 { return make(map[string]float64) })
+	if err != nil {
+		return err
+	}
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := strconv.ParseFloat(pairs[j], 64)
+		if err != nil {
+			return fmt.Errorf("invalid score %q", pairs[j])
+		}
+		zset[pairs[j+1]] = score
+	}
+	return nil
+}
+
+// XADD key id field value ...；AOF 中的 ID 都是具体的值
+func applyXadd(items map[string]*item, args []string) error {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	if args[1] == "*" || strings.HasSuffix(args[1], "-*") {
+		return fmt.Errorf("cannot convert auto-generated stream ID %q", args[1])
+	}
+	stream, err := lookupValue(items, args[0], func() *rdb.Stream { return &rdb.Stream{} })
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]string)
+	for i := 2; i < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	stream.Entries = append(stream.Entries, rdb.StreamEntry{ID: args[1], Fields: fields})
+	return nil
+}
+
+// XGROUP CREATE key group id [MKSTREAM] [ENTRIESREAD n]
+func applyXgroup(items map[string]*item, args []string) error {
+	if len(args) < 4 || strings.ToUpper(args[0]) != "CREATE" {
+		return errors.New("only XGROUP CREATE is supported")
+	}
+	group := rdb.ConsumerGroup{Name: args[2], LastID: args[3], EntriesRead: -1}
+	mkstream := false
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "MKSTREAM":
+			mkstream = true
+		case "ENTRIESREAD":
+			if i+1 >= len(args) {
+				return errors.New("syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid entries read %q", args[i+1])
+			}
+			group.EntriesRead = n
+			i++
+		}
+	}
+	if items[args[1]] == nil && !mkstream {
+		return fmt.Errorf("stream %q does not exist", args[1])
+	}
+	stream, err := lookupValue(items, args[1], func() *rdb.Stream { return &rdb.Stream{} })
+	if err != nil {
+		return err
+	}
+	if group.LastID == "$" {
+		group.LastID = "0-0"
+		if len(stream.Entries) > 0 {
+			group.LastID = stream.Entries[len(stream.Entries)-1].ID
+		}
+	}
+	stream.Groups = append(stream.Groups, group)
+	return nil
+}
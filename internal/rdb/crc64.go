@@ -0,0 +1,30 @@
+package rdb
+
+// Redis 使用的 CRC64 Jones 校验（反射多项式 0xad93d23594c935a9，初值 0，结果不取反）
+// 标准库 hash/crc64 会对初值和结果取反，算出来的和 redis-check-rdb 不一致，所以单独实现
+var crc64JonesTable = makeCRC64JonesTable()
+
+func makeCRC64JonesTable() *[256]uint64 {
+	const poly = 0x95ac9329ac4bc9b5 // 0xad93d23594c935a9 按位反转
+	table := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// 在已有校验值 crc 的基础上继续计算 data
+func crc64Jones(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64JonesTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
@@ -0,0 +1,190 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// listpack 编码，RDB 中的流（以及新版本的列表、哈希等小对象）使用这种紧凑格式
+// 结构：<总字节数 uint32><元素个数 uint16><元素...><0xFF>
+// 每个元素：<编码+数据><backlen>，backlen 记录编码+数据的长度，用于反向遍历
+type listpackBuilder struct {
+	entries []byte
+	count   int
+}
+
+// 追加字符串元素，可以表示为整数的字符串按整数编码（和 Redis 的 lpAppend 一致）
+func (lp *listpackBuilder) appendString(s string) {
+	if v, ok := canonicalInt(s); ok {
+		lp.appendInt(v)
+		return
+	}
+	var enc []byte
+	size := len(s)
+	switch {
+	case size < 64:
+		enc = []byte{0x80 | byte(size)}
+	case size < 4096:
+		enc = []byte{0xE0 | byte(size>>8), byte(size)}
+	default:
+		enc = []byte{0xF0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(enc[1:], uint32(size))
+	}
+	lp.appendEntry(append(enc, s...))
+}
+
+// 追加整数元素，选择能容纳该值的最短编码
+func (lp *listpackBuilder) appendInt(v int64) {
+	var enc []byte
+	switch {
+	case v >= 0 && v <= 127:
+		enc = []byte{byte(v)}
+	case v >= -4096 && v <= 4095:
+		u := uint64(v)
+		if v < 0 {
+			u = uint64((1 << 13) + v)
+		}
+		enc = []byte{0xC0 | byte(u>>8), byte(u)}
+	case v >= -32768 && v <= 32767:
+		enc = []byte{0xF1, 0, 0}
+		binary.LittleEndian.PutUint16(enc[1:], uint16(v))
+	case v >= -8388608 && v <= 8388607:
+		u := uint32(v)
+		enc = []byte{0xF2, byte(u), byte(u >> 8), byte(u >> 16)}
+	case v >= -2147483648 && v <= 2147483647:
+		enc = []byte{0xF3, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(enc[1:], uint32(v))
+	default:
+		enc = []byte{0xF4, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(enc[1:], uint64(v))
+	}
+	lp.appendEntry(enc)
+}
+
+func (lp *listpackBuilder) appendEntry(entry []byte) {
+	lp.entries = append(lp.entries, entry...)
+	lp.entries = append(lp.entries, encodeBacklen(len(entry))...)
+	lp.count++
+}
+
+// 生成完整的 listpack
+func (lp *listpackBuilder) bytes() []byte {
+	total := 6 + len(lp.entries) + 1
+	buf := make([]byte, 6, total)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(total))
+	count := lp.count
+	if count > 65535 {
+		count = 65535 // 超过 65535 时需要遍历才能知道元素个数
+	}
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(count))
+	buf = append(buf, lp.entries...)
+	return append(buf, 0xFF)
+}
+
+// backlen：每字节 7 位，从高位到低位，除第一个字节外最高位置 1
+func encodeBacklen(l int) []byte {
+	switch {
+	case l <= 127:
+		return []byte{byte(l)}
+	case l < 16383:
+		return []byte{byte(l >> 7), byte(l&127) | 128}
+	case l < 2097151:
+		return []byte{byte(l >> 14), byte((l>>7)&127) | 128, byte(l&127) | 128}
+	case l < 268435455:
+		return []byte{byte(l >> 21), byte((l>>14)&127) | 128, byte((l>>7)&127) | 128, byte(l&127) | 128}
+	default:
+		return []byte{byte(l >> 28), byte((l>>21)&127) | 128, byte((l>>14)&127) | 128, byte((l>>7)&127) | 128, byte(l&127) | 128}
+	}
+}
+
+// 字符串是否是规范的整数写法（没有前导零、正号或空格），是则返回其值
+func canonicalInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+// 解析 listpack，整数元素转换成十进制字符串
+func decodeListpack(lp []byte) ([]string, error) {
+	if len(lp) < 7 || int(binary.LittleEndian.Uint32(lp[0:4])) != len(lp) || lp[len(lp)-1] != 0xFF {
+		return nil, errors.New("invalid listpack header")
+	}
+	var items []string
+	p := 6
+	for lp[p] != 0xFF {
+		start := p
+		b := lp[p]
+		var size int // 编码头之后数据的长度
+		switch {
+		case b&0x80 == 0: // 7 位无符号整数
+			items = append(items, strconv.Itoa(int(b)))
+			p++
+		case b&0xC0 == 0x80: // 6 位长度字符串
+			size = int(b & 0x3F)
+			p++
+		case b&0xE0 == 0xC0: // 13 位有符号整数
+			if p+2 > len(lp) {
+				return nil, errors.New("listpack entry out of range")
+			}
+			v := int(b&0x1F)<<8 | int(lp[p+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			items = append(items, strconv.Itoa(v))
+			p += 2
+		case b&0xF0 == 0xE0: // 12 位长度字符串
+			if p+2 > len(lp) {
+				return nil, errors.New("listpack entry out of range")
+			}
+			size = int(b&0x0F)<<8 | int(lp[p+1])
+			p += 2
+		case b == 0xF0: // 32 位长度字符串
+			if p+5 > len(lp) {
+				return nil, errors.New("listpack entry out of range")
+			}
+			size = int(binary.LittleEndian.Uint32(lp[p+1 : p+5]))
+			p += 5
+		case b >= 0xF1 && b <= 0xF4: // 16/24/32/64 位有符号整数
+			width := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[b]
+			if p+1+width > len(lp) {
+				return nil, errors.New("listpack entry out of range")
+			}
+			items = append(items, strconv.FormatInt(littleEndianInt(lp[p+1:p+1+width]), 10))
+			p += 1 + width
+		default:
+			return nil, fmt.Errorf("invalid listpack encoding 0x%02X", b)
+		}
+		if b&0xC0 == 0x80 || b&0xF0 == 0xE0 || b == 0xF0 {
+			if p+size > len(lp) {
+				return nil, errors.New("listpack entry out of range")
+			}
+			items = append(items, string(lp[p:p+size]))
+			p += size
+		}
+		p += len(encodeBacklen(p - start))
+		if p >= len(lp) {
+			return nil, errors.New("listpack entry out of range")
+		}
+	}
+	if count := int(binary.LittleEndian.Uint16(lp[4:6])); count != 65535 && count != len(items) {
+		return nil, errors.New("listpack entry count mismatch")
+	}
+	return items, nil
+}
+
+// 按小端读取 width 字节的有符号整数
+func littleEndianInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(u<<shift) >> shift
+}
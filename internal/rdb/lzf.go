@@ -0,0 +1,182 @@
+package rdb
+
+import "errors"
+
+// LZF 压缩，移植自 liblzf（Redis 保存 RDB 时用它压缩较长的字符串）
+const (
+	lzfHashLog = 16
+	lzfMaxLit  = 1 << 5
+	lzfMaxOff  = 1 << 13
+	lzfMaxRef  = (1 << 8) + (1 << 3)
+)
+
+// 压缩 in，输出不能超过 outLen 字节，压缩后不够小时返回 nil
+func lzfCompress(in []byte, outLen int) []byte {
+	inLen := len(in)
+	if inLen == 0 || outLen <= 0 {
+		return nil
+	}
+	htab := make([]int, 1<<lzfHashLog)
+	out := make([]byte, outLen)
+	hashIndex := func(h uint32) uint32 {
+		return ((h >> (3*8 - lzfHashLog)) - h*5) & ((1 << lzfHashLog) - 1)
+	}
+
+	ip, op := 0, 0
+	lit := 0
+	op++ // 开始一段字面量
+
+	hval := uint32(in[0])<<8 | uint32(in[1%inLen])
+	for ip < inLen-2 {
+		hval = (hval << 8) | uint32(in[ip+2])
+		slot := hashIndex(hval)
+		ref := htab[slot]
+		htab[slot] = ip
+
+		if off := ip - ref - 1; ref < ip && off < lzfMaxOff && ref > 0 &&
+			in[ref+2] == in[ip+2] && in[ref] == in[ip] && in[ref+1] == in[ip+1] {
+			// 找到匹配
+			length := 2
+			maxLen := inLen - ip - length
+			if maxLen > lzfMaxRef {
+				maxLen = lzfMaxRef
+			}
+			if op+3+1 >= outLen {
+				if lit == 0 {
+					if op-1+3+1 >= outLen {
+						return nil
+					}
+				} else {
+					return nil
+				}
+			}
+
+			out[op-lit-1] = byte(lit - 1) // 结束字面量
+			if lit == 0 {
+				op-- // 空的字面量段不输出
+			}
+
+			for {
+				length++
+				if length >= maxLen || in[ref+length] != in[ip+length] {
+					break
+				}
+			}
+
+			length -= 2 // 现在是匹配长度减一
+			ip++
+
+			if length < 7 {
+				out[op] = byte((off >> 8) + (length << 5))
+				op++
+			} else {
+				out[op] = byte((off >> 8) + (7 << 5))
+				out[op+1] = byte(length - 7)
+				op += 2
+			}
+			out[op] = byte(off)
+			op++
+
+			lit = 0
+			op++ // 开始一段新的字面量
+
+			ip += length + 1
+			if ip >= inLen-2 {
+				break
+			}
+
+			ip--
+			hval = uint32(in[ip])<<8 | uint32(in[ip+1])
+			hval = (hval << 8) | uint32(in[ip+2])
+			htab[hashIndex(hval)] = ip
+			ip++
+		} else {
+			// 输出一个字面量字节
+			if op >= outLen {
+				return nil
+			}
+			lit++
+			out[op] = in[ip]
+			op++
+			ip++
+			if lit == lzfMaxLit {
+				out[op-lit-1] = byte(lit - 1)
+				lit = 0
+				op++
+			}
+		}
+	}
+
+	if op+3 > outLen {
+		return nil
+	}
+	for ip < inLen {
+		if op >= outLen {
+			return nil
+		}
+		lit++
+		out[op] = in[ip]
+		op++
+		ip++
+		if lit == lzfMaxLit {
+			if op >= outLen {
+				return nil
+			}
+			out[op-lit-1] = byte(lit - 1)
+			lit = 0
+			op++
+		}
+	}
+	out[op-lit-1] = byte(lit - 1)
+	if lit == 0 {
+		op--
+	}
+	return out[:op]
+}
+
+// 解压 LZF 数据，outLen 为原始长度
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	// 原始长度来自文件，按实际解压出的数据增长，不预先分配
+	out := make([]byte, 0, min(outLen, 2*len(in)))
+	ip := 0
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < lzfMaxLit {
+			// 字面量：ctrl+1 个字节
+			ctrl++
+			if ip+ctrl > len(in) || len(out)+ctrl > outLen {
+				return nil, errors.New("lzf: corrupt literal run")
+			}
+			out = append(out, in[ip:ip+ctrl]...)
+			ip += ctrl
+			continue
+		}
+
+		// 回溯引用
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errors.New("lzf: corrupt back reference")
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errors.New("lzf: corrupt back reference")
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1 - int(in[ip])
+		ip++
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, errors.New("lzf: back reference out of range")
+		}
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errors.New("lzf: decompressed length mismatch")
+	}
+	return out, nil
+}
@@ -0,0 +1,156 @@
+// Package rdb 读写 Redis 的 RDB 文件，服务端的持久化和 cmd/rdbtool 共用
+//
+// 值在内存中的表示：
+//
+//	string              字符串
+//	[]string            列表
+//	map[string]bool     集合
+//	map[string]string   哈希
+//	map[string]float64  有序集合（成员 -> 分数）
+//	*Stream             流
+package rdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RDB 格式常量
+const (
+	Version    = 11 // 写入的版本
+	MinVersion = 6  // 能读取的最低版本
+	MaxVersion = 12 // 能读取的最高版本
+
+	// 操作码
+	opcodeSlotInfo      = 0xF4
+	opcodeFunction2     = 0xF5
+	opcodeFunctionPreGA = 0xF6
+	opcodeModuleAux     = 0xF7
+	opcodeIdle          = 0xF8
+	opcodeFreq          = 0xF9
+	opcodeAux           = 0xFA
+	opcodeResizeDB      = 0xFB
+	opcodeExpireTimeMs  = 0xFC
+	opcodeExpireTime    = 0xFD
+	opcodeSelectDB      = 0xFE
+	opcodeEOF           = 0xFF
+
+	// 值类型
+	typeString              = 0
+	typeList                = 1
+	typeSet                 = 2
+	typeZset                = 3 // 分数以字符串保存
+	typeHash                = 4
+	typeZset2               = 5 // 分数以 8 字节 double 保存
+	typeModulePreGA         = 6
+	typeModule2             = 7
+	typeHashZipmap          = 9
+	typeListZiplist         = 10
+	typeSetIntset           = 11
+	typeZsetZiplist         = 12
+	typeHashZiplist         = 13
+	typeListQuicklist       = 14
+	typeStreamListpacks     = 15
+	typeHashListpack        = 16
+	typeZsetListpack        = 17
+	typeListQuicklist2      = 18
+	typeStreamListpacks2    = 19
+	typeSetListpack         = 20
+	typeStreamListpacks3    = 21
+	typeHashMetadataPreGA   = 22
+	typeHashListpackExPreGA = 23
+	typeHashMetadata        = 24 // 带字段过期时间的哈希（Redis 7.4）
+	typeHashListpackEx      = 25
+
+	// quicklist 节点的容器类型
+	quicklistNodePlain      = 1
+	quicklistNodePacked     = 2
+	quicklistNodeMaxEntries = 128 // 写入时每个 listpack 节点的最大元素数
+
+	// 流节点
+	streamNodeMaxEntries     = 100 // 和 stream-node-max-entries 默认值一致
+	streamItemFlagNone       = 0
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+)
+
+// 文件中的一个 key
+type Entry struct {
+	DB       uint64
+	Key      string
+	Value    interface{} // 见包注释；模块类型的值无法解析，为 nil
+	ExpireAt int64       // 过期时间（毫秒时间戳），0 表示不过期
+	Size     int64       // 在文件中占用的字节数（包括过期时间和类型）
+}
+
+// 流：条目和消费者组
+type Stream struct {
+	Entries []StreamEntry
+	Groups  []ConsumerGroup
+}
+
+// StreamEntry 代表 Redis Stream 的单个条目
+type StreamEntry struct {
+	ID     string            `json:"id"`
+	Fields map[string]string `json:"fields"`
+}
+
+// 流的消费者组
+type ConsumerGroup struct {
+	Name        string         `json:"name"`
+	LastID      string         `json:"last_id"`      // 最后投递的 ID
+	EntriesRead int64          `json:"entries_read"` // 已读取的条目数，-1 表示未知
+	Pending     []PendingEntry `json:"pending"`      // 已投递但未确认的条目（PEL），按 ID 排序
+	Consumers   []Consumer     `json:"consumers"`
+}
+
+// PEL 中的一个条目
+type PendingEntry struct {
+	ID            string `json:"id"`
+	Consumer      string `json:"consumer"`      // 条目当前属于的消费者
+	DeliveryTime  int64  `json:"delivery_time"` // 最后一次投递的时间（毫秒时间戳）
+	DeliveryCount int64  `json:"delivery_count"`
+}
+
+// 消费者组中的一个消费者
+type Consumer struct {
+	Name       string `json:"name"`
+	SeenTime   int64  `json:"seen_time"`   // 最后一次尝试读取的时间（毫秒时间戳）
+	ActiveTime int64  `json:"active_time"` // 最后一次成功读取的时间，-1 表示从未读取
+}
+
+// 值的类型名，和 TYPE 命令的返回一致；模块值为 module
+func TypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	case map[string]bool:
+		return "set"
+	case map[string]string:
+		return "hash"
+	case map[string]float64:
+		return "zset"
+	case *Stream:
+		return "stream"
+	case nil:
+		return "module"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// 解析 "<ms>-<seq>" 格式的流 ID，格式不对时返回 0-0
+func parseStreamID(id string) (uint64, uint64) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	ms, err1 := strconv.ParseUint(parts[0], 10, 64)
+	seq, err2 := strconv.ParseUint(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+	return ms, seq
+}
@@ -0,0 +1,883 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// 顺序读取 RDB 文件中的 key：边读边累计 CRC64，文件末尾用来校验
+type Reader struct {
+	r         *bufio.Reader
+	checksum  uint64
+	offset    int64 // 已读取的字节数
+	db        uint64
+	done      bool
+	Version   int               // RDB 版本
+	Aux       map[string]string // 辅助字段，如 redis-ver、ctime
+	Functions []string          // 函数库源码（本包只读出，不解析）
+}
+
+// 校验和不一致
+var ErrChecksum = errors.New("wrong RDB checksum")
+
+const (
+	maxStringLen = 512 << 20 // 字符串长度上限，和 Redis proto-max-bulk-len 的默认值相同，超过时认为文件损坏
+	bigReadLen   = 32 * 1024 // 超过这个长度时分块读取，损坏的长度不会在数据读到之前分配整块内存
+)
+
+// 创建读取器并读取文件头 REDIS<4 位版本号>
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{Aux: make(map[string]string)}
+	if br, ok := r.(*bufio.Reader); ok {
+		reader.r = br // 调用方已经带缓冲，避免多读走后面的数据
+	} else {
+		reader.r = bufio.NewReaderSize(r, 64*1024)
+	}
+
+	header, err := reader.readBytes(9)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, errors.New("wrong signature trying to load DB from file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < MinVersion || version > MaxVersion {
+		return nil, fmt.Errorf("can't handle RDB format version %s", header[5:])
+	}
+	reader.Version = version
+	return reader, nil
+}
+
+// 已读取的字节数，读完时包括末尾的校验和
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// 读满 n 个字节，读到一半遇到文件结束返回 io.ErrUnexpectedEOF
+func (r *Reader) readBytes(n int) ([]byte, error) {
+	var b []byte
+	if n <= bigReadLen {
+		b = make([]byte, n)
+		if _, err := io.ReadFull(r.r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r.r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		b = buf.Bytes()
+	}
+	r.checksum = crc64Jones(r.checksum, b)
+	r.offset += int64(n)
+	return b, nil
+}
+
+func (r *Reader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// 读取 8 字节小端的毫秒时间戳
+func (r *Reader) readMillis() (int64, error) {
+	b, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// 读取旧格式的 double：<长度><十进制字符串>，长度 253/254/255 分别表示 NaN、+inf、-inf
+func (r *Reader) readDouble() (float64, error) {
+	length, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := r.readBytes(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// 读取 8 字节小端 IEEE 754 double
+func (r *Reader) readBinaryDouble() (float64, error) {
+	b, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// 读取长度编码，encoded 为 true 时表示后面是特殊编码的字符串（11xxxxxx），返回值是编码类型
+func (r *Reader) readLength() (length uint64, encoded bool, err error) {
+	first, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3F), false, nil
+	case 1:
+		next, err := r.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch first {
+		case 0x80:
+			b, err := r.readBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(b)), false, nil
+		case 0x81:
+			b, err := r.readBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(b), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown length encoding 0x%02X", first)
+	}
+	return uint64(first & 0x3F), true, nil
+}
+
+// 读取长度编码的数值，不允许特殊编码
+func (r *Reader) readSize() (uint64, error) {
+	length, encoded, err := r.readLength()
+	if err == nil && encoded {
+		err = errors.New("unexpected string encoding where a length was expected")
+	}
+	return length, err
+}
+
+// 读取字符串：原始字符串、8/16/32 位整数编码或 LZF 压缩
+func (r *Reader) readString() (string, error) {
+	length, encoded, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if err := checkStringLen(length); err != nil {
+			return "", err
+		}
+		b, err := r.readBytes(int(length))
+		return string(b), err
+	}
+
+	switch length {
+	case 0: // 8 位整数
+		b, err := r.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case 1: // 16 位整数
+		b, err := r.readBytes(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case 2: // 32 位整数
+		b, err := r.readBytes(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case 3: // LZF 压缩
+		compressedLen, err := r.readSize()
+		if err != nil {
+			return "", err
+		}
+		originalLen, err := r.readSize()
+		if err != nil {
+			return "", err
+		}
+		if err := checkStringLen(compressedLen); err != nil {
+			return "", err
+		}
+		if err := checkStringLen(originalLen); err != nil {
+			return "", err
+		}
+		compressed, err := r.readBytes(int(compressedLen))
+		if err != nil {
+			return "", err
+		}
+		original, err := lzfDecompress(compressed, int(originalLen))
+		return string(original), err
+	}
+	return "", fmt.Errorf("unknown string encoding %d", length)
+}
+
+// 字符串长度超过上限时返回错误，避免损坏的长度导致分配超大的内存
+func checkStringLen(length uint64) error {
+	if length > maxStringLen {
+		return fmt.Errorf("string length %d exceeds the limit of %d bytes", length, maxStringLen)
+	}
+	return nil
+}
+
+// 读取下一个 key，文件结束并且校验和正确时返回 io.EOF
+// 格式：文件头之后是一系列操作码，直到 0xFF 和 8 字节 CRC64 校验和
+func (r *Reader) Next() (*Entry, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	start := r.offset
+	expireAt := int64(0)
+	for {
+		opcode, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opcodeEOF:
+			// 校验和覆盖 0xFF 在内的所有字节，为 0 表示保存时关闭了 rdbchecksum
+			expected := r.checksum
+			b, err := r.readBytes(8)
+			if err != nil {
+				return nil, err
+			}
+			if stored := binary.LittleEndian.Uint64(b); stored != 0 && stored != expected {
+				return nil, fmt.Errorf("%w expected: (%x) got: (%x)", ErrChecksum, stored, expected)
+			}
+			r.done = true
+			return nil, io.EOF
+
+		case opcodeAux:
+			key, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			if r.Aux[key], err = r.readString(); err != nil {
+				return nil, err
+			}
+
+		case opcodeSelectDB:
+			if r.db, err = r.readSize(); err != nil {
+				return nil, err
+			}
+
+		case opcodeResizeDB:
+			// 哈希表大小和过期表大小，只是预分配的提示
+			if _, err := r.readSize(); err != nil {
+				return nil, err
+			}
+			if _, err := r.readSize(); err != nil {
+				return nil, err
+			}
+
+		case opcodeExpireTimeMs:
+			if expireAt, err = r.readMillis(); err != nil {
+				return nil, err
+			}
+			continue
+
+		case opcodeExpireTime:
+			b, err := r.readBytes(4)
+			if err != nil {
+				return nil, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(b)) * 1000
+			continue
+
+		case opcodeIdle:
+			// LRU 空闲时间，不使用
+			if _, err := r.readSize(); err != nil {
+				return nil, err
+			}
+			continue
+
+		case opcodeFreq:
+			// LFU 计数，不使用
+			if _, err := r.readByte(); err != nil {
+				return nil, err
+			}
+			continue
+
+		case opcodeSlotInfo:
+			// 集群槽信息：槽号、槽大小、过期 key 数
+			for i := 0; i < 3; i++ {
+				if _, err := r.readSize(); err != nil {
+					return nil, err
+				}
+			}
+
+		case opcodeFunction2:
+			code, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			r.Functions = append(r.Functions, code)
+
+		case opcodeModuleAux, opcodeFunctionPreGA:
+			return nil, fmt.Errorf("RDB opcode 0x%02X (modules / pre-GA functions) is not supported", opcode)
+
+		default:
+			key, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.readObject(opcode)
+			if err != nil {
+				return nil, fmt.Errorf("loading key '%s': %v", key, err)
+			}
+			return &Entry{DB: r.db, Key: key, Value: value, ExpireAt: expireAt, Size: r.offset - start}, nil
+		}
+		start = r.offset // 不属于某个 key 的操作码不计入 key 的大小
+	}
+}
+
+// 读取一个值，值的表示见包注释；模块类型的值被跳过，返回 nil
+// 未知类型无法确定数据长度，只能报错停止，不能继续往后读
+func (reader *Reader) readObject(valueType byte) (interface{}, error) {
+	switch valueType {
+	case typeString:
+		return reader.readString()
+
+	case typeList:
+		return readStringList(reader)
+
+	case typeSet:
+		members, err := readStringList(reader)
+		if err != nil {
+			return nil, err
+		}
+		return toSet(members), nil
+
+	case typeZset, typeZset2:
+		size, err := reader.readSize()
+		if err != nil {
+			return nil, err
+		}
+		zset := make(map[string]float64, min(size, 1<<16))
+		for i := uint64(0); i < size; i++ {
+			member, err := reader.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == typeZset2 {
+				score, err = reader.readBinaryDouble()
+			} else {
+				score, err = reader.readDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zset[member] = score
+		}
+		return zset, nil
+
+	case typeHash:
+		items, err := readStringPairs(reader)
+		if err != nil {
+			return nil, err
+		}
+		return toHash(items)
+
+	case typeHashZipmap, typeListZiplist, typeSetIntset, typeZsetZiplist,
+		typeHashZiplist, typeHashListpack, typeZsetListpack, typeSetListpack:
+		// 整个值是一个紧凑编码的字符串
+		blob, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		var items []string
+		switch valueType {
+		case typeHashZipmap:
+			items, err = decodeZipmap([]byte(blob))
+		case typeSetIntset:
+			items, err = decodeIntset([]byte(blob))
+		case typeListZiplist, typeZsetZiplist, typeHashZiplist:
+			items, err = decodeZiplist([]byte(blob))
+		default:
+			items, err = decodeListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch valueType {
+		case typeListZiplist:
+			return items, nil
+		case typeSetIntset, typeSetListpack:
+			return toSet(items), nil
+		case typeZsetZiplist, typeZsetListpack:
+			return toZset(items)
+		default:
+			return toHash(items)
+		}
+
+	case typeListQuicklist, typeListQuicklist2:
+		// quicklist：节点数，每个节点是一个 ziplist（旧版）或 <容器类型> + listpack/单个元素（新版）
+		nodes, err := reader.readSize()
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for i := uint64(0); i < nodes; i++ {
+			container := uint64(quicklistNodePacked)
+			if valueType == typeListQuicklist2 {
+				if container, err = reader.readSize(); err != nil {
+					return nil, err
+				}
+			}
+			blob, err := reader.readString()
+			if err != nil {
+				return nil, err
+			}
+			if container == quicklistNodePlain {
+				list = append(list, blob)
+				continue
+			}
+			var items []string
+			if valueType == typeListQuicklist2 {
+				items, err = decodeListpack([]byte(blob))
+			} else {
+				items, err = decodeZiplist([]byte(blob))
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, items...)
+		}
+		return list, nil
+
+	case typeHashMetadata, typeHashMetadataPreGA, typeHashListpackEx, typeHashListpackExPreGA:
+		return readHashWithFieldTTL(reader, valueType)
+
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return readStream(reader, valueType)
+
+	case typeModule2:
+		return nil, skipModuleValue(reader)
+	}
+	return nil, fmt.Errorf("unknown RDB value type %d", valueType)
+}
+
+// 读取 <元素个数><字符串...>
+func readStringList(reader *Reader) ([]string, error) {
+	size, err := reader.readSize()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, min(size, 1<<16))
+	for i := uint64(0); i < size; i++ {
+		item, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 读取 <键值对个数><键><值>...，平铺成一个切片
+func readStringPairs(reader *Reader) ([]string, error) {
+	size, err := reader.readSize()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, min(2*size, 1<<16))
+	for i := uint64(0); i < 2*size; i++ {
+		item, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func toSet(members []string) map[string]bool {
+	set := make(map[string]bool, len(members))
+	for _, member := range members {
+		set[member] = true
+	}
+	return set
+}
+
+func toHash(items []string) (map[string]string, error) {
+	if len(items)%2 != 0 {
+		return nil, errors.New("hash has an odd number of elements")
+	}
+	hash := make(map[string]string, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		hash[items[i]] = items[i+1]
+	}
+	return hash, nil
+}
+
+func toZset(items []string) (map[string]float64, error) {
+	if len(items)%2 != 0 {
+		return nil, errors.New("sorted set has an odd number of elements")
+	}
+	zset := make(map[string]float64, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(items[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sorted set score '%s'", items[i+1])
+		}
+		zset[items[i]] = score
+	}
+	return zset, nil
+}
+
+// 读取带字段过期时间的哈希（Redis 7.4），已过期的字段不载入
+// 本服务不支持字段级过期，未过期字段的过期时间会被丢弃
+func readHashWithFieldTTL(reader *Reader, valueType byte) (map[string]string, error) {
+	var minExpire int64
+	var err error
+	if valueType == typeHashMetadata || valueType == typeHashListpackEx {
+		// 正式版在开头保存最小过期时间，字段的过期时间相对它存储
+		if minExpire, err = reader.readMillis(); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().UnixNano() / 1e6
+	hash := make(map[string]string)
+
+	if valueType == typeHashListpackEx || valueType == typeHashListpackExPreGA {
+		// listpack 中每个字段占三个元素：字段、值、过期时间（0 表示不过期）
+		blob, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		items, err := decodeListpack([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		if len(items)%3 != 0 {
+			return nil, errors.New("hash listpack has an invalid number of elements")
+		}
+		for i := 0; i < len(items); i += 3 {
+			ttl, _ := strconv.ParseInt(items[i+2], 10, 64)
+			if ttl == 0 || ttl > now {
+				hash[items[i]] = items[i+1]
+			}
+		}
+		return hash, nil
+	}
+
+	// 每个字段：<过期时间><字段><值>，正式版的过期时间保存为 expire - minExpire + 1，0 表示不过期
+	size, err := reader.readSize()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < size; i++ {
+		ttl, err := reader.readSize()
+		if err != nil {
+			return nil, err
+		}
+		expireAt := int64(ttl)
+		if valueType == typeHashMetadata && ttl != 0 {
+			expireAt += minExpire - 1
+		}
+		field, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		if expireAt == 0 || expireAt > now {
+			hash[field] = value
+		}
+	}
+	return hash, nil
+}
+
+// 读取流：listpack 节点、元数据和消费者组
+// STREAM_LISTPACKS_2 起多了第一个 ID、最大已删除 ID、累计添加数和消费者组的 entries_read，
+// STREAM_LISTPACKS_3 起消费者多了 active_time
+func readStream(reader *Reader, valueType byte) (*Stream, error) {
+	stream := &Stream{Entries: []StreamEntry{}}
+	nodes, err := reader.readSize()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		nodeKey, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(nodeKey) != 16 {
+			return nil, errors.New("stream node key is not a 128 bit ID")
+		}
+		blob, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		items, err := decodeListpack([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		entries, err := decodeStreamNode(rawStreamID([]byte(nodeKey)), items)
+		if err != nil {
+			return nil, err
+		}
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	// 长度和最后一个 ID，之后的元数据可以从条目中重新得到，只需读过
+	metadata := 3
+	if valueType >= typeStreamListpacks2 {
+		metadata += 5
+	}
+	for i := 0; i < metadata; i++ {
+		if _, err := reader.readSize(); err != nil {
+			return nil, err
+		}
+	}
+
+	groupCount, err := reader.readSize()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groupCount; i++ {
+		group, err := readStreamGroup(reader, valueType)
+		if err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+// 读取一个消费者组：名字、最后投递 ID、[entries_read]、PEL 和消费者
+func readStreamGroup(reader *Reader, valueType byte) (ConsumerGroup, error) {
+	group := ConsumerGroup{EntriesRead: -1}
+	var err error
+	if group.Name, err = reader.readString(); err != nil {
+		return group, err
+	}
+	lastMs, err := reader.readSize()
+	if err != nil {
+		return group, err
+	}
+	lastSeq, err := reader.readSize()
+	if err != nil {
+		return group, err
+	}
+	group.LastID = fmt.Sprintf("%d-%d", lastMs, lastSeq)
+	if valueType >= typeStreamListpacks2 {
+		entriesRead, err := reader.readSize()
+		if err != nil {
+			return group, err
+		}
+		group.EntriesRead = int64(entriesRead)
+	}
+
+	// 组的 PEL：<原始 ID 16 字节><投递时间><投递次数>
+	pelSize, err := reader.readSize()
+	if err != nil {
+		return group, err
+	}
+	pending := make(map[string]int, min(pelSize, 1<<16)) // ID -> 在 Pending 中的位置
+	for i := uint64(0); i < pelSize; i++ {
+		raw, err := reader.readBytes(16)
+		if err != nil {
+			return group, err
+		}
+		entry := PendingEntry{ID: rawStreamID(raw)}
+		if entry.DeliveryTime, err = reader.readMillis(); err != nil {
+			return group, err
+		}
+		count, err := reader.readSize()
+		if err != nil {
+			return group, err
+		}
+		entry.DeliveryCount = int64(count)
+		pending[entry.ID] = len(group.Pending)
+		group.Pending = append(group.Pending, entry)
+	}
+
+	// 消费者：<名字><seen_time>[<active_time>]<自己的 PEL：原始 ID...>
+	consumerCount, err := reader.readSize()
+	if err != nil {
+		return group, err
+	}
+	for i := uint64(0); i < consumerCount; i++ {
+		consumer := Consumer{ActiveTime: -1}
+		if consumer.Name, err = reader.readString(); err != nil {
+			return group, err
+		}
+		if consumer.SeenTime, err = reader.readMillis(); err != nil {
+			return group, err
+		}
+		if valueType >= typeStreamListpacks3 {
+			if consumer.ActiveTime, err = reader.readMillis(); err != nil {
+				return group, err
+			}
+		}
+		owned, err := reader.readSize()
+		if err != nil {
+			return group, err
+		}
+		for j := uint64(0); j < owned; j++ {
+			raw, err := reader.readBytes(16)
+			if err != nil {
+				return group, err
+			}
+			index, ok := pending[rawStreamID(raw)]
+			if !ok {
+				return group, errors.New("consumer PEL entry not found in the group PEL")
+			}
+			group.Pending[index].Consumer = consumer.Name
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+// 解析一个流节点的 listpack：
+// <count><deleted><主字段数><主字段...><0>，然后每个条目 <flags><ms 差值><seq 差值>
+// [<字段数><字段><值>... | 与主字段相同时只有 <值>...]<条目元素数>
+func decodeStreamNode(masterID string, items []string) ([]StreamEntry, error) {
+	masterMs, masterSeq := parseStreamID(masterID)
+	next := func() (int64, error) {
+		if len(items) == 0 {
+			return 0, errors.New("stream listpack truncated")
+		}
+		v, err := strconv.ParseInt(items[0], 10, 64)
+		items = items[1:]
+		return v, err
+	}
+	take := func(n int64) ([]string, error) {
+		if n < 0 || int64(len(items)) < n {
+			return nil, errors.New("stream listpack truncated")
+		}
+		taken := items[:n]
+		items = items[n:]
+		return taken, nil
+	}
+
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := next()
+	if err != nil {
+		return nil, err
+	}
+	masterFieldCount, err := next()
+	if err != nil {
+		return nil, err
+	}
+	masterFields, err := take(masterFieldCount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := next(); err != nil { // 主条目结束标记 0
+		return nil, err
+	}
+
+	var entries []StreamEntry
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string)
+		if flags&streamItemFlagSameFields != 0 {
+			values, err := take(masterFieldCount)
+			if err != nil {
+				return nil, err
+			}
+			for j, field := range masterFields {
+				fields[field] = values[j]
+			}
+		} else {
+			fieldCount, err := next()
+			if err != nil {
+				return nil, err
+			}
+			pairs, err := take(2 * fieldCount)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < len(pairs); j += 2 {
+				fields[pairs[j]] = pairs[j+1]
+			}
+		}
+		if _, err := next(); err != nil { // lp-count，用于反向遍历
+			return nil, err
+		}
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, StreamEntry{
+				ID:     fmt.Sprintf("%d-%d", uint64(masterMs)+uint64(msDiff), uint64(masterSeq)+uint64(seqDiff)),
+				Fields: fields,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// 16 字节大端的原始流 ID
+func rawStreamID(raw []byte) string {
+	return fmt.Sprintf("%d-%d", binary.BigEndian.Uint64(raw[0:8]), binary.BigEndian.Uint64(raw[8:16]))
+}
+
+// 跳过模块类型的值：<模块 ID>，然后是若干 <操作码><数据>，直到操作码 0
+func skipModuleValue(reader *Reader) error {
+	if _, err := reader.readSize(); err != nil { // 模块 ID
+		return err
+	}
+	for {
+		opcode, err := reader.readSize()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case 0: // EOF
+			return nil
+		case 1, 2: // 有符号、无符号整数
+			_, err = reader.readSize()
+		case 3: // float
+			_, err = reader.readBytes(4)
+		case 4: // double
+			_, err = reader.readBytes(8)
+		case 5: // 字符串
+			_, err = reader.readString()
+		default:
+			return fmt.Errorf("unknown module value opcode %d", opcode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// 顺序写入 RDB 文件，边写边累计 CRC64
+// 用法：WriteHeader，WriteAux...，SelectDB，WriteEntry...，Close
+type Writer struct {
+	w        io.Writer
+	checksum uint64
+	err      error // 第一次写入失败的错误，之后的写入都直接返回它
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) write(p []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.checksum = crc64Jones(w.checksum, p)
+	_, w.err = w.w.Write(p)
+	return w.err
+}
+
+// 写入文件头 REDIS<4 位版本号>
+func (w *Writer) WriteHeader() error {
+	return w.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+}
+
+// 写入一个辅助字段：0xFA <key> <value>
+func (w *Writer) WriteAux(key, value string) error {
+	var buf bytes.Buffer
+	buf.WriteByte(opcodeAux)
+	writeString(&buf, key)
+	writeString(&buf, value)
+	return w.write(buf.Bytes())
+}
+
+// 开始一个数据库：SELECTDB <db>，RESIZEDB <键总数> <设置了过期时间的键数>
+func (w *Writer) SelectDB(db uint64, keys, expires int) error {
+	var buf bytes.Buffer
+	buf.WriteByte(opcodeSelectDB)
+	writeLengthEncodedInt(&buf, db)
+	buf.WriteByte(opcodeResizeDB)
+	writeLengthEncodedInt(&buf, uint64(keys))
+	writeLengthEncodedInt(&buf, uint64(expires))
+	return w.write(buf.Bytes())
+}
+
+// 写入一个 key，expireAt 为 0 表示不过期；值的表示见包注释
+// 有过期时间的键先写 0xFC 和 8 字节小端毫秒时间戳，然后是值类型、key 和值
+func (w *Writer) WriteEntry(key string, value interface{}, expireAt int64) error {
+	var buf bytes.Buffer
+	if expireAt != 0 {
+		buf.WriteByte(opcodeExpireTimeMs)
+		writeMillis(&buf, expireAt)
+	}
+
+	switch v := value.(type) {
+	case string:
+		buf.WriteByte(typeString)
+		writeString(&buf, key)
+		writeString(&buf, v)
+
+	case []string:
+		// 列表：quicklist，每个节点是一个 listpack
+		buf.WriteByte(typeListQuicklist2)
+		writeString(&buf, key)
+		writeLengthEncodedInt(&buf, uint64((len(v)+quicklistNodeMaxEntries-1)/quicklistNodeMaxEntries))
+		for start := 0; start < len(v); start += quicklistNodeMaxEntries {
+			var lp listpackBuilder
+			for _, item := range v[start:min(start+quicklistNodeMaxEntries, len(v))] {
+				lp.appendString(item)
+			}
+			writeLengthEncodedInt(&buf, quicklistNodePacked)
+			writeString(&buf, string(lp.bytes()))
+		}
+
+	case map[string]bool:
+		buf.WriteByte(typeSet)
+		writeString(&buf, key)
+		writeLengthEncodedInt(&buf, uint64(len(v)))
+		for member := range v {
+			writeString(&buf, member)
+		}
+
+	case map[string]float64:
+		// 有序集合：分数以 8 字节 double 保存
+		buf.WriteByte(typeZset2)
+		writeString(&buf, key)
+		writeLengthEncodedInt(&buf, uint64(len(v)))
+		for member, score := range v {
+			writeString(&buf, member)
+			scoreBytes := make([]byte, 8)
+			binary.LittleEndian.PutUint64(scoreBytes, math.Float64bits(score))
+			buf.Write(scoreBytes)
+		}
+
+	case map[string]string:
+		buf.WriteByte(typeHash)
+		writeString(&buf, key)
+		writeLengthEncodedInt(&buf, uint64(len(v)))
+		for field, value := range v {
+			writeString(&buf, field)
+			writeString(&buf, value)
+		}
+
+	case *Stream:
+		buf.WriteByte(typeStreamListpacks3)
+		writeString(&buf, key)
+		writeStream(&buf, v.Entries, v.Groups)
+
+	default:
+		return fmt.Errorf("can't save value of type %T for key '%s'", value, key)
+	}
+	return w.write(buf.Bytes())
+}
+
+// 写入文件尾部：0xFF 和对之前所有字节（包括 0xFF）计算的 CRC64 校验和（8 字节小端）
+// 不会关闭底层的 io.Writer
+func (w *Writer) Close() error {
+	if err := w.write([]byte{opcodeEOF}); err != nil {
+		return err
+	}
+	checksumBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksumBytes, w.checksum)
+	return w.write(checksumBytes)
+}
+
+// 写入 8 字节小端的毫秒时间戳
+func writeMillis(buf *bytes.Buffer, ms int64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(ms))
+	buf.Write(b)
+}
+
+// 写入 RDB 字符串：能表示为 32 位以内整数的用整数编码，较长的尝试 LZF 压缩，否则写长度前缀加原始内容
+func writeString(buf *bytes.Buffer, str string) {
+	// 整数编码：0xC0/0xC1/0xC2 后跟 1/2/4 字节小端整数
+	if len(str) <= 11 {
+		if v, ok := canonicalInt(str); ok {
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				buf.Write([]byte{0xC0, byte(int8(v))})
+				return
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				b := []byte{0xC1, 0, 0}
+				binary.LittleEndian.PutUint16(b[1:], uint16(int16(v)))
+				buf.Write(b)
+				return
+			case v >= math.MinInt32 && v <= math.MaxInt32:
+				b := []byte{0xC2, 0, 0, 0, 0}
+				binary.LittleEndian.PutUint32(b[1:], uint32(int32(v)))
+				buf.Write(b)
+				return
+			}
+		}
+	}
+
+	// LZF 压缩：0xC3 <压缩后长度> <原始长度> <压缩数据>，压缩后至少要小 4 字节才值得
+	if len(str) > 20 {
+		if compressed := lzfCompress([]byte(str), len(str)-4); compressed != nil {
+			buf.WriteByte(0xC3)
+			writeLengthEncodedInt(buf, uint64(len(compressed)))
+			writeLengthEncodedInt(buf, uint64(len(str)))
+			buf.Write(compressed)
+			return
+		}
+	}
+
+	// 原始字符串
+	writeLengthEncodedInt(buf, uint64(len(str)))
+	buf.WriteString(str)
+}
+
+// 按 RDB 长度编码写入整数
+// 00xxxxxx：6 位；01xxxxxx xxxxxxxx：14 位；0x80 + 4 字节大端；0x81 + 8 字节大端
+func writeLengthEncodedInt(buf *bytes.Buffer, value uint64) {
+	switch {
+	case value < 1<<6:
+		buf.WriteByte(byte(value))
+	case value < 1<<14:
+		buf.Write([]byte{0x40 | byte(value>>8), byte(value)})
+	case value <= math.MaxUint32:
+		b := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(value))
+		buf.Write(b)
+	default:
+		b := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], value)
+		buf.Write(b)
+	}
+}
+
+// 写入流（RDB_TYPE_STREAM_LISTPACKS_3）：
+// listpack 节点数，每个节点 <主 ID 16 字节大端> <listpack>，
+// 然后是长度、最后/第一个/最大已删除 ID、累计添加数和消费者组
+func writeStream(buf *bytes.Buffer, entries []StreamEntry, groups []ConsumerGroup) {
+	nodes := (len(entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	writeLengthEncodedInt(buf, uint64(nodes))
+	for start := 0; start < len(entries); start += streamNodeMaxEntries {
+		end := start + streamNodeMaxEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		writeString(buf, string(encodeRawStreamID(entries[start].ID)))
+		writeString(buf, string(encodeStreamNode(entries[start:end])))
+	}
+
+	var lastMs, lastSeq, firstMs, firstSeq uint64
+	if len(entries) > 0 {
+		firstMs, firstSeq = parseStreamID(entries[0].ID)
+		lastMs, lastSeq = parseStreamID(entries[len(entries)-1].ID)
+	}
+	writeLengthEncodedInt(buf, uint64(len(entries)))
+	writeLengthEncodedInt(buf, lastMs)
+	writeLengthEncodedInt(buf, lastSeq)
+	writeLengthEncodedInt(buf, firstMs)
+	writeLengthEncodedInt(buf, firstSeq)
+	writeLengthEncodedInt(buf, 0) // 最大已删除 ID（没有 XDEL，始终为 0-0）
+	writeLengthEncodedInt(buf, 0)
+	writeLengthEncodedInt(buf, uint64(len(entries))) // 累计添加的条目数
+
+	writeLengthEncodedInt(buf, uint64(len(groups)))
+	for _, group := range groups {
+		writeStreamGroup(buf, group)
+	}
+}
+
+// 写入一个消费者组：名字、最后投递 ID、entries_read、PEL 和消费者
+// PEL 中的 ID 直接写 16 字节大端，不带长度前缀
+func writeStreamGroup(buf *bytes.Buffer, group ConsumerGroup) {
+	writeString(buf, group.Name)
+	lastMs, lastSeq := parseStreamID(group.LastID)
+	writeLengthEncodedInt(buf, lastMs)
+	writeLengthEncodedInt(buf, lastSeq)
+	writeLengthEncodedInt(buf, uint64(group.EntriesRead)) // -1 按无符号写入，和 Redis 一致
+
+	writeLengthEncodedInt(buf, uint64(len(group.Pending)))
+	for _, entry := range group.Pending {
+		buf.Write(encodeRawStreamID(entry.ID))
+		writeMillis(buf, entry.DeliveryTime)
+		writeLengthEncodedInt(buf, uint64(entry.DeliveryCount))
+	}
+
+	writeLengthEncodedInt(buf, uint64(len(group.Consumers)))
+	for _, consumer := range group.Consumers {
+		writeString(buf, consumer.Name)
+		writeMillis(buf, consumer.SeenTime)
+		writeMillis(buf, consumer.ActiveTime)
+		var owned []string
+		for _, entry := range group.Pending {
+			if entry.Consumer == consumer.Name {
+				owned = append(owned, entry.ID)
+			}
+		}
+		writeLengthEncodedInt(buf, uint64(len(owned)))
+		for _, id := range owned {
+			buf.Write(encodeRawStreamID(id))
+		}
+	}
+}
+
+// 流 ID 编码成 16 字节大端
+func encodeRawStreamID(id string) []byte {
+	ms, seq := parseStreamID(id)
+	raw := make([]byte, 16)
+	binary.BigEndian.PutUint64(raw[0:8], ms)
+	binary.BigEndian.PutUint64(raw[8:16], seq)
+	return raw
+}
+
+// 把一组条目编码成一个流节点的 listpack
+// 主条目：count、deleted、字段数、字段名...、0；
+// 每个条目：flags、ms 差值、seq 差值、[字段数、字段名]、值...、lp-count
+// 字段和主条目相同时设置 SAMEFIELDS 标志，只保存值
+func encodeStreamNode(entries []StreamEntry) []byte {
+	masterMs, masterSeq := parseStreamID(entries[0].ID)
+	masterFields := sortedFieldNames(entries[0].Fields)
+
+	var lp listpackBuilder
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0)
+
+	for _, entry := range entries {
+		ms, seq := parseStreamID(entry.ID)
+		fields := sortedFieldNames(entry.Fields)
+		sameFields := equalStrings(fields, masterFields)
+
+		flags := int64(streamItemFlagNone)
+		if sameFields {
+			flags = streamItemFlagSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(ms - masterMs))
+		lp.appendInt(int64(seq - masterSeq))
+		if !sameFields {
+			lp.appendInt(int64(len(fields)))
+		}
+		for _, field := range fields {
+			if !sameFields {
+				lp.appendString(field)
+			}
+			lp.appendString(entry.Fields[field])
+		}
+		lpCount := int64(len(fields)) + 3
+		if !sameFields {
+			lpCount += int64(len(fields)) + 1
+		}
+		lp.appendInt(lpCount)
+	}
+	return lp.bytes()
+}
+
+// 字段名排序后返回（Fields 是 map，没有插入顺序）
+func sortedFieldNames(fields map[string]string) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// 旧版本 RDB（Redis 7.0 之前）里小对象使用的紧凑编码：ziplist、intset 和 zipmap，只需要能读取
+
+// 解析 ziplist：<zlbytes uint32><zltail uint32><zllen uint16><元素...><0xFF>
+// 每个元素：<前一个元素长度 1 或 5 字节><编码><数据>
+func decodeZiplist(zl []byte) ([]string, error) {
+	if len(zl) < 11 || int(binary.LittleEndian.Uint32(zl[0:4])) != len(zl) || zl[len(zl)-1] != 0xFF {
+		return nil, errors.New("invalid ziplist header")
+	}
+	var items []string
+	p := 10
+	for zl[p] != 0xFF {
+		if zl[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(zl) {
+			return nil, errors.New("ziplist entry out of range")
+		}
+		b := zl[p]
+		var size int // 字符串长度，整数编码时为 -1
+		switch b >> 6 {
+		case 0:
+			size = int(b & 0x3F)
+			p++
+		case 1:
+			size = int(b&0x3F)<<8 | int(zl[p+1])
+			p += 2
+		case 2:
+			if p+5 > len(zl) {
+				return nil, errors.New("ziplist entry out of range")
+			}
+			size = int(binary.BigEndian.Uint32(zl[p+1 : p+5]))
+			p += 5
+		default:
+			size = -1
+			width := 0
+			switch b {
+			case 0xC0:
+				width = 2
+			case 0xD0:
+				width = 4
+			case 0xE0:
+				width = 8
+			case 0xF0:
+				width = 3
+			case 0xFE:
+				width = 1
+			default:
+				if b < 0xF1 || b > 0xFD {
+					return nil, errors.New("invalid ziplist encoding")
+				}
+				items = append(items, strconv.Itoa(int(b&0x0F)-1)) // 1111xxxx：0 到 12 的立即数
+			}
+			p++
+			if width > 0 {
+				if p+width > len(zl) {
+					return nil, errors.New("ziplist entry out of range")
+				}
+				items = append(items, strconv.FormatInt(littleEndianInt(zl[p:p+width]), 10))
+				p += width
+			}
+		}
+		if size >= 0 {
+			if p+size > len(zl) {
+				return nil, errors.New("ziplist entry out of range")
+			}
+			items = append(items, string(zl[p:p+size]))
+			p += size
+		}
+		if p >= len(zl) {
+			return nil, errors.New("ziplist entry out of range")
+		}
+	}
+	return items, nil
+}
+
+// 解析 intset：<每个整数的字节数 uint32><元素个数 uint32><小端整数...>
+func decodeIntset(is []byte) ([]string, error) {
+	if len(is) < 8 {
+		return nil, errors.New("invalid intset header")
+	}
+	width := int(binary.LittleEndian.Uint32(is[0:4]))
+	count := int(binary.LittleEndian.Uint32(is[4:8]))
+	if (width != 2 && width != 4 && width != 8) || len(is) != 8+width*count {
+		return nil, errors.New("invalid intset size")
+	}
+	items := make([]string, 0, count)
+	for p := 8; p < len(is); p += width {
+		items = append(items, strconv.FormatInt(littleEndianInt(is[p:p+width]), 10))
+	}
+	return items, nil
+}
+
+// 解析 zipmap（Redis 2.6 之前的小哈希）：<zmlen><len>field<len><free>value<free 个空字节>...<0xFF>
+// 长度小于 254 时占 1 字节，否则为 254 加 4 字节小端长度
+func decodeZipmap(zm []byte) ([]string, error) {
+	var items []string
+	p := 1
+	readLen := func() (int, error) {
+		if p >= len(zm) {
+			return 0, errors.New("zipmap entry out of range")
+		}
+		if zm[p] < 254 {
+			p++
+			return int(zm[p-1]), nil
+		}
+		if zm[p] == 254 && p+5 <= len(zm) {
+			p += 5
+			return int(binary.LittleEndian.Uint32(zm[p-4 : p])), nil
+		}
+		return 0, errors.New("invalid zipmap length")
+	}
+	for p < len(zm) && zm[p] != 0xFF {
+		size, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if p+size > len(zm) {
+			return nil, errors.New("zipmap entry out of range")
+		}
+		field := string(zm[p : p+size])
+		p += size
+
+		if size, err = readLen(); err != nil {
+			return nil, err
+		}
+		if p >= len(zm) {
+			return nil, errors.New("zipmap entry out of range")
+		}
+		free := int(zm[p])
+		p++
+		if p+size+free > len(zm) {
+			return nil, errors.New("zipmap entry out of range")
+		}
+		items = append(items, field, string(zm[p:p+size]))
+		p += size + free
+	}
+	if p >= len(zm) {
+		return nil, errors.New("zipmap missing end marker")
+	}
+	return items, nil
+}
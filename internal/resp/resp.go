@@ -0,0 +1,141 @@
+// Package resp 解析客户端发送的 RESP 请求，网络连接、AOF 载入、DEBUG IMPORT 和 slave 接收复制流共用
+//
+// 客户端声明的参数个数和长度都要检查限制，和 Redis 一样：
+// 未通过认证的连接最多 10 个参数、每个参数 16KB，认证后由 proto-max-bulk-len 限制
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxMultibulk   = 1024 * 1024 // 一条命令最多的参数个数
+	MaxInline      = 64 * 1024   // 内联命令和长度行的最大长度（PROTO_INLINE_MAX_SIZE）
+	DefaultMaxBulk = 512 << 20   // proto-max-bulk-len 的默认值
+
+	bigArg = 32 * 1024 // 超过这个长度的参数边接收边分配内存，声明了长度却不发送数据的客户端不会让服务器先分配整块内存
+)
+
+// 解析请求时的长度限制，超过限制按协议错误处理
+type Limits struct {
+	MaxMultibulk int   // 参数个数上限
+	MaxBulk      int64 // 单个参数的长度上限
+}
+
+// 已认证客户端的默认限制
+var DefaultLimits = Limits{MaxMultibulk: MaxMultibulk, MaxBulk: DefaultMaxBulk}
+
+// 未认证客户端的限制
+var UnauthenticatedLimits = Limits{MaxMultibulk: 10, MaxBulk: 16384}
+
+// 严格按照 RESP 格式读取一条命令，返回参数和读取的字节数
+// 在命令边界遇到文件结束返回 io.EOF，命令不完整返回 io.ErrUnexpectedEOF，格式错误或者超过限制返回其他错误
+func ReadCommand(r *bufio.Reader, limits Limits) ([]string, int, error) {
+	line, err := readLine(r, "too big mbulk count string")
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(line)
+	if !strings.HasPrefix(line, "*") || !strings.HasSuffix(line, "\r\n") {
+		return nil, total, errors.New("expected '*'")
+	}
+	count, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || count < 1 || count > limits.MaxMultibulk {
+		return nil, total, errors.New("invalid multibulk length")
+	}
+
+	argv := make([]string, 0, min(count, 1024))
+	for i := 0; i < count; i++ {
+		lenLine, err := readLine(r, "too big bulk count string")
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, total, err
+		}
+		total += len(lenLine)
+		if !strings.HasPrefix(lenLine, "$") || !strings.HasSuffix(lenLine, "\r\n") {
+			return nil, total, errors.New("expected '$'")
+		}
+		size, err := strconv.ParseInt(lenLine[1:len(lenLine)-2], 10, 64)
+		if err != nil || size < 0 || size > limits.MaxBulk {
+			return nil, total, errors.New("invalid bulk length")
+		}
+		data, err := readBulk(r, size+2)
+		if err != nil {
+			return nil, total, err
+		}
+		total += len(data)
+		if data[size] != '\r' || data[size+1] != '\n' {
+			return nil, total, errors.New("bulk string not terminated by CRLF")
+		}
+		argv = append(argv, string(data[:size]))
+	}
+	return argv, total, nil
+}
+
+// 读取一条请求：以 * 开头的按 RESP 解析，否则是内联命令（telnet 等），按空白分割，空行跳过
+func ReadRequest(r *bufio.Reader, limits Limits) ([]string, error) {
+	for {
+		prefix, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if prefix[0] == '*' {
+			argv, _, err := ReadCommand(r, limits)
+			return argv, err
+		}
+		line, err := readLine(r, "too big inline request")
+		if err != nil {
+			return nil, err
+		}
+		if args := strings.Fields(line); len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// 读取以 \n 结尾的一行，最长 MaxInline 字节，超过时返回 tooLong 错误
+// 一个字节都没有读到时返回 io.EOF，读到一部分返回 io.ErrUnexpectedEOF
+func readLine(r *bufio.Reader, tooLong string) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > MaxInline {
+			return "", errors.New(tooLong)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) == 0:
+			return "", io.EOF
+		case err == io.EOF:
+			return "", io.ErrUnexpectedEOF
+		case err != nil:
+			return "", err
+		}
+		return string(line), nil
+	}
+}
+
+// 读取 n 字节的参数数据（包括结尾的 \r\n）
+func readBulk(r *bufio.Reader, n int64) ([]byte, error) {
+	if n <= bigArg {
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, n); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	small := Limits{MaxMultibulk: 3, MaxBulk: 8}
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		argv   []string
+		n      int
+		err    string // 期望的错误信息，空表示没有错误
+	}{
+		{"simple", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", DefaultLimits, []string{"GET", "k"}, 20, ""},
+		{"binary value", "*2\r\n$3\r\nSET\r\n$4\r\na\r\nb\r\n", DefaultLimits, []string{"SET", "a\r\nb"}, 23, ""},
+		{"empty bulk", "*1\r\n$0\r\n\r\n", DefaultLimits, []string{""}, 10, ""},
+		{"at limits", "*3\r\n$8\r\n12345678\r\n$1\r\na\r\n$1\r\nb\r\n", small, []string{"12345678", "a", "b"}, 32, ""},
+		{"eof at boundary", "", DefaultLimits, nil, 0, io.EOF.Error()},
+		{"truncated count line", "*2", DefaultLimits, nil, 0, io.ErrUnexpectedEOF.Error()},
+		{"truncated length line", "*1\r\n$3", DefaultLimits, nil, 0, io.ErrUnexpectedEOF.Error()},
+		{"truncated bulk", "*1\r\n$3\r\nfo", DefaultLimits, nil, 0, io.ErrUnexpectedEOF.Error()},
+		{"missing argument", "*2\r\n$3\r\nfoo\r\n", DefaultLimits, nil, 0, io.ErrUnexpectedEOF.Error()},
+		{"huge bulk without data", "*1\r\n$536870912\r\nabc", DefaultLimits, nil, 0, io.ErrUnexpectedEOF.Error()},
+		{"not an array", "$3\r\nfoo\r\n", DefaultLimits, nil, 0, "expected '*'"},
+		{"count without crlf", "*1\n$1\r\na\r\n", DefaultLimits, nil, 0, "expected '*'"},
+		{"zero count", "*0\r\n", DefaultLimits, nil, 0, "invalid multibulk length"},
+		{"negative count", "*-1\r\n", DefaultLimits, nil, 0, "invalid multibulk length"},
+		{"bad count", "*abc\r\n", DefaultLimits, nil, 0, "invalid multibulk length"},
+		{"count over limit", "*4\r\n", small, nil, 0, "invalid multibulk length"},
+		{"count over default limit", "*1048577\r\n", DefaultLimits, nil, 0, "invalid multibulk length"},
+		{"not a bulk", "*1\r\n:1\r\n", DefaultLimits, nil, 0, "expected '$'"},
+		{"negative bulk length", "*1\r\n$-1\r\n", DefaultLimits, nil, 0, "invalid bulk length"},
+		{"bad bulk length", "*1\r\n$x\r\n", DefaultLimits, nil, 0, "invalid bulk length"},
+		{"bulk over limit", "*1\r\n$9\r\n123456789\r\n", small, nil, 0, "invalid bulk length"},
+		{"oversized bulk length", "*1\r\n$9999999999999\r\n", DefaultLimits, nil, 0, "invalid bulk length"},
+		{"bulk length overflow", "*1\r\n$99999999999999999999\r\n", DefaultLimits, nil, 0, "invalid bulk length"},
+		{"bulk without crlf", "*1\r\n$3\r\nfooXY", DefaultLimits, nil, 0, "bulk string not terminated by CRLF"},
+		{"unauthenticated count", "*11\r\n", UnauthenticatedLimits, nil, 0, "invalid multibulk length"},
+		{"unauthenticated bulk", "*1\r\n$16385\r\n", UnauthenticatedLimits, nil, 0, "invalid bulk length"},
+		{"long count line", "*" + strings.Repeat("1", MaxInline) + "\r\n", DefaultLimits, nil, 0, "too big mbulk count string"},
+		{"long length line", "*1\r\n$" + strings.Repeat("1", MaxInline) + "\r\n", DefaultLimits, nil, 0, "too big bulk count string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, n, err := ReadCommand(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(argv, tt.argv) || n != tt.n {
+				t.Fatalf("got %q (%d bytes), want %q (%d bytes)", argv, n, tt.argv, tt.n)
+			}
+		})
+	}
+}
+
+func TestReadCommandLeavesFollowingData(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nQUIT\r\n"))
+	for _, want := range []string{"PING", "QUIT"} {
+		argv, _, err := ReadCommand(r, DefaultLimits)
+		if err != nil || len(argv) != 1 || argv[0] != want {
+			t.Fatalf("got %q, %v, want %s", argv, err, want)
+		}
+	}
+	if _, _, err := ReadCommand(r, DefaultLimits); err != io.EOF {
+		t.Fatalf("error = %v, want EOF", err)
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		argv  []string
+		err   string
+	}{
+		{"inline", "PING\r\n", []string{"PING"}, ""},
+		{"inline with blank lines", "\r\n\n SET a  b\n", []string{"SET", "a", "b"}, ""},
+		{"multibulk", "*1\r\n$4\r\nPING\r\n", []string{"PING"}, ""},
+		{"eof", "", nil, io.EOF.Error()},
+		{"inline without newline", "PING", nil, io.ErrUnexpectedEOF.Error()},
+		{"inline too long", strings.Repeat("a", MaxInline+1), nil, "too big inline request"},
+		{"multibulk error", "*1\r\n$-5\r\n", nil, "invalid bulk length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := ReadRequest(bufio.NewReader(strings.NewReader(tt.input)), DefaultLimits)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(argv, tt.argv) {
+				t.Fatalf("got %q, %v, want %q", argv, err, tt.argv)
+			}
+		})
+	}
+}